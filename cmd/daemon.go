@@ -0,0 +1,143 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/ngenator/aws-mfa/mfa"
+	"github.com/spf13/cobra"
+)
+
+var (
+	daemonSocket string
+	daemonHTTP   string
+	daemonShell  string
+)
+
+// daemonCmd keeps credentials fresh in memory and serves them to other processes
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keeps temporary AWS credentials fresh in memory and serves them locally",
+	Long: `Keeps an MFA session in memory and derives temporary AWS credentials from it before they expire. You're
+only prompted for a token code when the session itself expires. The credentials file isn't written to.
+
+Each connection to the unix socket ('--socket') is sent the credentials in the credential_process format and closed:
+
+  credential_process = nc -U /home/me/.aws/aws-mfa-work.sock
+
+With '--http', the credentials are also served in the ECS container credentials format on a loopback address. The
+variables SDKs need to use it are printed on startup:
+
+  aws-mfa daemon --profile work --http 127.0.0.1:9911`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := mfa.GetShell(daemonShell)
+		if err != nil {
+			return err
+		}
+
+		refresher, err := mfa.NewRefresher(config, mfa.NewSTS)
+		if err != nil {
+			return err
+		}
+
+		daemon, err := mfa.NewDaemon(refresher)
+		if err != nil {
+			return err
+		}
+
+		// the daemon never writes the credentials file, so don't keep others
+		// from refreshing it
+		config.Close()
+
+		// prompt for the first token code before serving anything
+		if _, err := daemon.Credentials(); err != nil {
+			return err
+		}
+
+		if daemonSocket == "" {
+			daemonSocket = filepath.Join(filepath.Dir(config.Options.CredentialsFileLocation), fmt.Sprintf("aws-mfa-%s.sock", config.Options.Profile))
+		}
+		agent, err := mfa.ListenAgent(daemonSocket)
+		if err != nil {
+			return err
+		}
+
+		var container net.Listener
+		var token string
+		if daemonHTTP != "" {
+			if token, err = authorizationToken(); err != nil {
+				agent.Close()
+				return err
+			}
+
+			if container, err = mfa.ListenLoopback(daemonHTTP); err != nil {
+				agent.Close()
+				return err
+			}
+
+			fmt.Fprint(cmd.OutOrStdout(), mfa.Exports(s, []mfa.Variable{
+				{Name: "AWS_CONTAINER_CREDENTIALS_FULL_URI", Value: "http://" + container.Addr().String() + "/"},
+				{Name: "AWS_CONTAINER_AUTHORIZATION_TOKEN", Value: token},
+			}))
+		}
+
+		// shut down cleanly when stopped
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		go func() {
+			<-signals
+			cancel()
+		}()
+
+		return daemon.Serve(ctx, agent, container, token)
+	},
+}
+
+// authorizationToken generates a random token for the container credentials endpoint
+func authorizationToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func init() {
+	daemonCmd.Flags().StringVar(&daemonSocket, "socket", "", "path of the unix socket to serve credentials on (default \"aws-mfa-<profile>.sock\" next to the credentials file)")
+	daemonCmd.Flags().StringVar(&daemonHTTP, "http", "", "loopback address to serve container credentials on, e.g. 127.0.0.1:9911")
+	daemonCmd.Flags().StringVar(&daemonShell, "shell", defaultShell(), fmt.Sprintf("shell syntax to print the container credentials variables in, one of %s", strings.Join(mfa.Shells(), ", ")))
+	rootCmd.AddCommand(daemonCmd)
+}
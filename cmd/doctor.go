@@ -0,0 +1,83 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ngenator/aws-mfa/mfa"
+	"github.com/spf13/cobra"
+)
+
+// doctorCmd checks the configuration of every profile and reports what's wrong
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks your profiles and reports every problem found",
+	Long: `Makes the same checks as loading a profile, for every profile with a permanent section or the ones given with
+'--profile', but reports every problem found along with how to fix it instead of stopping at the first one. Nothing is
+changed and no requests are made to AWS. The exit code is non-zero if there are any problems.
+
+  aws-mfa doctor`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	// the checks load the credentials file themselves
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options, err := newOptions()
+		if err != nil {
+			return err
+		}
+
+		var problems []mfa.Problem
+		if cmd.Flags().Changed("profile") {
+			problems = options.Diagnose(profiles)
+		} else {
+			problems = options.Diagnose(nil)
+		}
+
+		out := cmd.OutOrStdout()
+		if len(problems) == 0 {
+			fmt.Fprintln(out, "No problems found")
+			return nil
+		}
+
+		for _, problem := range problems {
+			if problem.Profile != "" {
+				fmt.Fprintf(out, "%s: ", problem.Profile)
+			}
+			fmt.Fprintln(out, problem.Err)
+			if fix := problem.Fix(); fix != "" {
+				fmt.Fprintf(out, "  %s\n", fix)
+			}
+		}
+
+		if len(problems) == 1 {
+			return fmt.Errorf("found a problem")
+		}
+		return fmt.Errorf("found %d problems", len(problems))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
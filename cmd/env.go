@@ -0,0 +1,89 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ngenator/aws-mfa/mfa"
+	"github.com/spf13/cobra"
+)
+
+var (
+	shell string
+)
+
+// envCmd prints shell commands that export temporary credentials
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Prints shell commands that export temporary AWS credentials",
+	Long: `Prints shell commands that export temporary AWS credentials, refreshing them first if needed. AWS_PROFILE
+is unset so the exported credentials are used.
+
+  # bash/zsh
+  eval "$(aws-mfa env --profile work)"
+
+  # fish
+  aws-mfa env --profile work --shell fish | source
+
+  # PowerShell
+  aws-mfa env --profile work --shell powershell | Invoke-Expression`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := mfa.GetShell(shell)
+		if err != nil {
+			return err
+		}
+
+		refresher, err := mfa.NewRefresher(config, mfa.NewSTS)
+		if err != nil {
+			return err
+		}
+
+		variables, err := refresher.Environment()
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprint(cmd.OutOrStdout(), mfa.Exports(s, variables))
+		return err
+	},
+}
+
+// defaultShell guesses the shell from the environment
+func defaultShell() string {
+	if runtime.GOOS == "windows" {
+		return "powershell"
+	}
+	if name := filepath.Base(os.Getenv("SHELL")); strings.Contains(name, "fish") {
+		return "fish"
+	}
+	return "bash"
+}
+
+func init() {
+	envCmd.Flags().StringVar(&shell, "shell", defaultShell(), fmt.Sprintf("syntax of the printed commands, one of: %s", strings.Join(mfa.Shells(), ", ")))
+	rootCmd.AddCommand(envCmd)
+}
@@ -0,0 +1,97 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/ngenator/aws-mfa/mfa"
+	"github.com/spf13/cobra"
+)
+
+// execCmd runs a command with temporary credentials in its environment
+var execCmd = &cobra.Command{
+	Use:   "exec -- <command> [args...]",
+	Short: "Runs a command with temporary AWS credentials in its environment",
+	Long: `Runs a command with temporary AWS credentials in its environment, refreshing them first if needed.
+AWS_PROFILE is unset for the command so the credentials in the environment are used.
+
+  aws-mfa exec --profile work -- aws s3 ls`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		refresher, err := mfa.NewRefresher(config, mfa.NewSTS)
+		if err != nil {
+			return err
+		}
+
+		variables, err := refresher.Environment()
+		if err != nil {
+			return err
+		}
+
+		// the command may run aws-mfa itself, so don't hold on to the lock
+		config.Close()
+
+		child := exec.Command(args[0], args[1:]...)
+		child.Env = mfa.Environ(os.Environ(), variables)
+		child.Stdin = os.Stdin
+		child.Stdout = os.Stdout
+		child.Stderr = os.Stderr
+
+		if err := child.Start(); err != nil {
+			return err
+		}
+
+		// pass signals along to the command instead of exiting before it does
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		go func() {
+			for sig := range signals {
+				child.Process.Signal(sig)
+			}
+		}()
+
+		if err := child.Wait(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+					// exit like a shell does when the command was killed
+					if status.Signaled() {
+						os.Exit(128 + int(status.Signal()))
+					}
+					os.Exit(status.ExitStatus())
+				}
+			}
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	// flags after the command are its own, even without --
+	execCmd.Flags().SetInterspersed(false)
+	rootCmd.AddCommand(execCmd)
+}
@@ -0,0 +1,60 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ngenator/aws-mfa/mfa"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importKeyStore        string
+	importKeyStoreCommand string
+)
+
+// importCmd moves the access key of the permanent section into another key store
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Moves the permanent AWS access key out of the credentials file",
+	Long: `Moves the access key of the permanent section out of the credentials file, into a file encrypted with a
+passphrase or a key store plugin. The permanent section is marked with 'key_store' so aws-mfa reads the key from
+there afterwards. The passphrase of the encrypted file is read from AWS_MFA_PASSPHRASE, or asked for on the terminal.
+
+Plugins are commands that are run with 'get', 'store' or 'erase' appended. They're given a JSON request like
+{"profile": "work-permanent"} on stdin, which includes "aws_access_key_id" and "aws_secret_access_key" when storing,
+write the same keys as JSON to stdout for 'get', and exit with a non-zero status when they fail.
+
+  aws-mfa import --profile work
+  aws-mfa import --profile work --key-store exec --key-store-command "aws-mfa pass-helper"`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return config.ImportAccessKey(importKeyStore, importKeyStoreCommand)
+	},
+}
+
+func init() {
+	importCmd.Flags().StringVar(&importKeyStore, "key-store", mfa.EncryptedKeyStore, fmt.Sprintf("key store to move the access key to, one of: %s", strings.Join([]string{mfa.EncryptedKeyStore, mfa.ExecKeyStore}, ", ")))
+	importCmd.Flags().StringVar(&importKeyStoreCommand, "key-store-command", "", "plugin command used by the exec key store")
+	rootCmd.AddCommand(importCmd)
+}
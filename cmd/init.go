@@ -0,0 +1,66 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ngenator/aws-mfa/mfa"
+	"github.com/spf13/cobra"
+)
+
+// initCmd asks for the details of a new profile and writes its permanent section
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Sets up the permanent section of a new profile",
+	Long: `Asks for the name of the profile and your access key, then finds your MFA device by listing the MFA devices of
+the user the key belongs to and writes a <profile>-<suffix> section with 'mfa_serial' set. The secret access key isn't
+echoed. The credentials file is created if it doesn't exist. Use '--profile' and '--mfa' to skip those questions, and
+'--force' to replace the access key and MFA device of an existing profile.
+
+Your user needs to be allowed iam:ListMFADevices on itself, otherwise you're asked for the ARN of the device.
+
+  aws-mfa init --profile work`,
+	Args: cobra.NoArgs,
+	// the credentials file may not exist yet
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options, err := newOptions()
+		if err != nil {
+			return err
+		}
+
+		if cmd.Flags().Changed("profile") {
+			if len(profiles) != 1 {
+				return fmt.Errorf("%s only supports a single --profile", cmd.Name())
+			}
+			options.Profile = profiles[0]
+		}
+
+		return options.Init(mfa.TerminalPrompter{}, mfa.NewIAM)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+}
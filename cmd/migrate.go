@@ -0,0 +1,70 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateUndo bool
+
+// migrateCmd renames the section of a plain profile to its permanent section
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Moves a plain profile with a long-lived access key into a permanent section",
+	Long: `Renames the section of a profile that holds a long-lived access key, like [work], to its permanent section,
+[work-permanent] or whatever '--suffix' gives. Everything in the section is kept, and the credentials file is backed
+up next to itself with a timestamp first. Use '--undo' to rename it back, which removes the temporary credentials
+written to [work] since.
+
+  aws-mfa migrate --profile work
+  aws-mfa migrate --profile work --undo`,
+	Args: cobra.NoArgs,
+	// the permanent section doesn't exist until the profile is migrated
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options, err := newOptions()
+		if err != nil {
+			return err
+		}
+
+		if len(profiles) != 1 {
+			return fmt.Errorf("%s only supports a single --profile", cmd.Name())
+		}
+		options.Profile = profiles[0]
+
+		if migrateUndo {
+			_, err = options.UndoMigrateProfile()
+		} else {
+			_, err = options.MigrateProfile()
+		}
+		return err
+	},
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&migrateUndo, "undo", false, "rename the permanent section back to the profile")
+	rootCmd.AddCommand(migrateCmd)
+}
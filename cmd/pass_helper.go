@@ -0,0 +1,57 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/ngenator/aws-mfa/mfa"
+	"github.com/spf13/cobra"
+)
+
+var passStore = mfa.NewPassKeyStore()
+
+// passHelperCmd is a key store plugin that keeps access keys in pass
+var passHelperCmd = &cobra.Command{
+	Use:   "pass-helper get|store|erase",
+	Short: "Key store plugin that keeps the permanent AWS access keys in pass",
+	Long: `Implements the key store plugin protocol with pass, the standard unix password manager. Each profile is kept in
+an entry like aws-mfa/work-permanent, with the secret access key on the first line and the access key ID on an
+'aws_access_key_id:' line. It's used by setting the key store of a profile to it:
+
+  aws-mfa import --profile work --key-store exec --key-store-command "aws-mfa pass-helper"`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{mfa.PluginGet, mfa.PluginStore, mfa.PluginErase},
+	// the plugin runs while aws-mfa holds the lock on the credentials file,
+	// so it mustn't load it
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return mfa.ServePlugin(passStore, args[0], os.Stdin, cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	passHelperCmd.Flags().StringVar(&passStore.Prefix, "prefix", passStore.Prefix, "folder of the pass entries")
+	passHelperCmd.Flags().StringVar(&passStore.Command, "pass", passStore.Command, "path to the pass executable")
+	rootCmd.AddCommand(passHelperCmd)
+}
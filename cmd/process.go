@@ -0,0 +1,72 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+
+	"github.com/ngenator/aws-mfa/mfa"
+	"github.com/spf13/cobra"
+)
+
+// processCache is set when the temporary credentials are loaded for
+// credential_process, to keep them out of the credentials file
+var processCache bool
+
+// processCmd prints temporary credentials for use with credential_process
+var processCmd = &cobra.Command{
+	Use:   "process",
+	Short: "Prints temporary AWS credentials in the credential_process format",
+	Long: `Prints temporary AWS credentials as the JSON document expected from a 'credential_process'. Cached
+credentials are used while they're valid, otherwise they're refreshed first.
+
+The credentials are cached in 'aws-mfa-process' next to the credentials file, or the '--output-file' or
+'temp_credentials_file' of the profile, never in the credentials file itself. The CLI and SDKs read the credentials
+file before running the process, so the profile mustn't have a section with an access key there:
+
+  # ~/.aws/config
+  [profile work]
+  credential_process = aws-mfa process --profile work`,
+	Args: cobra.NoArgs,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		processCache = true
+		return rootCmd.PersistentPreRunE(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		refresher, err := mfa.NewRefresher(config, mfa.NewSTS)
+		if err != nil {
+			return err
+		}
+
+		credentials, err := refresher.ProcessCredentials()
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(credentials)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(processCmd)
+}
@@ -21,7 +21,10 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"text/tabwriter"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws/external"
@@ -31,42 +34,84 @@ import (
 
 var (
 	credentialsFile string
-	profile         string
+	configFile      string
+	outputFile      string
+	keyStoreFile    string
+	profiles        []string
+	allProfiles     bool
 	mfaSerial       string
+	token           string
+	roleArn         string
+	roleSessionName string
+	externalID      string
 	duration        time.Duration
+	clampDuration   bool
 	suffix          string
+	minRemaining    string
+	keyAgeWarning   string
+	maxKeyAge       string
+	mfaAttempts     int
+	clearOnFailure  bool
+	skipVerify      bool
+	lockTimeout     time.Duration
 	force           bool
 	verbose         bool
 )
 
 var (
 	config *mfa.Config
+
+	// configs is set instead of config when refreshing several profiles
+	configs []*mfa.Config
+
+	// invalidProfiles are the profiles that failed to validate when
+	// refreshing several, they're reported along with the rest
+	invalidProfiles []mfa.Result
 )
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use: "aws-mfa",
+	Use:   "aws-mfa",
 	Short: "Refreshes or generates temporary AWS credentials",
 	Long: `Refreshes or generates temporary AWS credentials via STS. If you use the '--mfa' flag, the ARN will be
-stored in the credentials file so you don't have to pass it every time. If you already have credentials with an
-expiration that's an hour out or further, they won't be refreshed unless you use the '--force' flag.`,
-	PreRunE: func(cmd *cobra.Command, args []string) error {
-		options := mfa.Options{
-			CredentialsFileLocation: credentialsFile,
-			Profile:                 profile,
-			ProfileSuffix:           suffix,
-			MFASerial:               mfaSerial,
-			Duration:                duration,
-			Force:                   force,
-			Verbose:                 verbose,
+stored in the credentials file so you don't have to pass it every time. If you already have credentials with more
+than an hour, or half of their lifetime, left they won't be refreshed unless you use the '--force' flag. Use
+'--min-remaining' or 'refresh_before' to change how much needs to be left.
+
+If a role ARN is given with '--role-arn' or 'role_arn' is set in the permanent section, the role will be assumed
+using your MFA device instead of requesting a session token.
+
+Several profiles can be refreshed at once by repeating '--profile', or with '--all' for every profile that has a
+permanent section. You're only prompted once per MFA device.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		options, err := newOptions()
+		if err != nil {
+			return err
+		}
+
+		if !cmd.HasParent() && (allProfiles || len(profiles) > 1) {
+			if allProfiles {
+				configs, invalidProfiles, err = options.ValidateEachProfile(nil)
+			} else {
+				configs, invalidProfiles, err = options.ValidateEachProfile(profiles)
+			}
+			return err
+		}
+
+		if allProfiles || len(profiles) != 1 {
+			return fmt.Errorf("%s only supports a single --profile", cmd.Name())
 		}
 
-		var err error
+		options.Profile = profiles[0]
 		config, err = options.Validate()
 		return err
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		refresher, err := mfa.NewRefresher(config)
+		if config == nil {
+			return refreshProfiles(cmd.OutOrStdout())
+		}
+
+		refresher, err := mfa.NewRefresher(config, mfa.NewSTS)
 		if err != nil {
 			return err
 		}
@@ -79,17 +124,130 @@ expiration that's an hour out or further, they won't be refreshed unless you use
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute(version string) {
 	rootCmd.Version = version
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	if config != nil {
+		config.Close()
+	}
+	if len(configs) > 0 {
+		configs[0].Close()
+	}
+	if err != nil {
+		if configErr, ok := err.(mfa.ConfigError); ok {
+			fmt.Fprintln(os.Stderr, configErr.Fix())
+		}
 		os.Exit(1)
 	}
 }
 
+// newOptions builds the options from the persistent flags, without a profile
+func newOptions() (mfa.Options, error) {
+	options := mfa.Options{
+		CredentialsFileLocation: credentialsFile,
+		ConfigFileLocation:      configFile,
+		OutputFileLocation:      outputFile,
+		ProcessCache:            processCache,
+		KeyStoreFile:            keyStoreFile,
+		ProfileSuffix:           suffix,
+		MFASerial:               mfaSerial,
+		Token:                   token,
+		RoleARN:                 roleArn,
+		RoleSessionName:         roleSessionName,
+		ExternalID:              externalID,
+		Duration:                duration,
+		ClampDuration:           clampDuration,
+		MFAAttempts:             mfaAttempts,
+		ClearOnFailure:          clearOnFailure,
+		SkipVerify:              skipVerify,
+		LockTimeout:             lockTimeout,
+		Force:                   force,
+		Verbose:                 verbose,
+	}
+
+	if minRemaining != "" {
+		threshold, err := mfa.ParseThreshold(minRemaining)
+		if err != nil {
+			return options, err
+		}
+		options.RefreshBefore = threshold
+	}
+
+	if keyAgeWarning != "" {
+		age, err := mfa.ParseAge(keyAgeWarning)
+		if err != nil {
+			return options, err
+		}
+		options.KeyAgeWarning = age
+	}
+
+	if maxKeyAge != "" {
+		age, err := mfa.ParseAge(maxKeyAge)
+		if err != nil {
+			return options, err
+		}
+		options.MaxKeyAge = age
+	}
+
+	return options, nil
+}
+
+// refreshProfiles refreshes every profile in configs and prints a summary,
+// including the profiles that failed to validate
+func refreshProfiles(out io.Writer) error {
+	results := append(mfa.RefreshProfiles(configs, mfa.NewSTS), invalidProfiles...)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tSTATUS\tEXPIRES IN")
+
+	failed := 0
+	for _, result := range results {
+		status, expires := "valid", time.Until(result.Expires).Round(time.Second).String()
+		switch {
+		case result.Err != nil:
+			failed++
+			status, expires = "failed: "+result.Err.Error(), "-"
+		case result.Refreshed:
+			status = "refreshed"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.Profile, status, expires)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d profiles failed to refresh", failed, len(results))
+	}
+	return nil
+}
+
+// defaultConfigFile honors AWS_CONFIG_FILE the same way the AWS CLI does
+func defaultConfigFile() string {
+	if file := os.Getenv("AWS_CONFIG_FILE"); file != "" {
+		return file
+	}
+	return external.DefaultSharedConfigFilename()
+}
+
 func init() {
-	rootCmd.Flags().StringVarP(&profile, "profile", "p", external.DefaultSharedConfigProfile, "profile that will contain the temporary credentials within the AWS shared credentials file")
-	rootCmd.Flags().StringVarP(&credentialsFile, "credentials", "c", external.DefaultSharedCredentialsFilename(), "path to AWS shared credentials file")
-	rootCmd.Flags().BoolVarP(&force, "force", "f", false, "force a refresh even if unexpired credentials exist")
-	rootCmd.Flags().BoolVar(&verbose, "verbose", false, "enable verbose logging")
-	rootCmd.Flags().DurationVarP(&duration, "duration", "d", time.Hour*36, "amount of time the temporary credentials are valid, min: 15m, max: 36h")
-	rootCmd.Flags().StringVarP(&suffix, "suffix", "s", "permanent", "suffix to append to profile, used to find permanent credentials. results in <profile>-<suffix>")
-	rootCmd.Flags().StringVarP(&mfaSerial, "mfa", "m", "", "arn of your mfa device, e.g. `arn:aws:iam::<account-id>:mfa/<user>` uses one defined in the credentials file if exists and omitted")
+	rootCmd.PersistentFlags().StringSliceVarP(&profiles, "profile", "p", []string{external.DefaultSharedConfigProfile}, "profile that will contain the temporary credentials within the AWS shared credentials file, can be repeated to refresh several")
+	rootCmd.Flags().BoolVar(&allProfiles, "all", false, "refresh every profile that has a <profile>-<suffix> section")
+	rootCmd.PersistentFlags().StringVarP(&credentialsFile, "credentials", "c", external.DefaultSharedCredentialsFilename(), "path to AWS shared credentials file")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", defaultConfigFile(), "path to AWS shared config file, used to look up mfa_serial, role_arn, region and duration_seconds")
+	rootCmd.PersistentFlags().StringVar(&outputFile, "output-file", "", "path to a separate file the temporary credentials are written to, uses temp_credentials_file if set and omitted (default the credentials file)")
+	rootCmd.PersistentFlags().StringVar(&keyStoreFile, "key-store-file", "", "path to the encrypted key store used by profiles with key_store = encrypted (default aws-mfa-keys next to the credentials file)")
+	rootCmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", mfa.DefaultLockTimeout, "how long to wait for another aws-mfa to release the credentials file, unless it's refreshing")
+	rootCmd.PersistentFlags().IntVar(&mfaAttempts, "mfa-attempts", mfa.DefaultMFAAttempts, "how many times to ask for the token code when the one typed in is rejected")
+	rootCmd.PersistentFlags().BoolVar(&clearOnFailure, "clear-on-failure", false, "remove the temporary credentials when refreshing them fails, by default they're kept")
+	rootCmd.PersistentFlags().BoolVarP(&force, "force", "f", false, "force a refresh even if unexpired credentials exist")
+	rootCmd.PersistentFlags().BoolVar(&skipVerify, "skip-verify", false, "don't check new credentials with GetCallerIdentity before keeping them")
+	rootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "enable verbose logging")
+	rootCmd.PersistentFlags().StringVar(&minRemaining, "min-remaining", "", "refresh credentials with less than this left, a duration or a percentage of their lifetime, e.g. 30m or 25% (default 1h or 50%, whichever is less)")
+	rootCmd.PersistentFlags().StringVar(&keyAgeWarning, "key-age-warning", "", "warn when the permanent access key is older than this, a number of days or a duration, e.g. 60d (default 90d)")
+	rootCmd.PersistentFlags().StringVar(&maxKeyAge, "max-key-age", "", "fail instead of refreshing when the permanent access key is older than this, e.g. 180d")
+	rootCmd.PersistentFlags().DurationVarP(&duration, "duration", "d", 0, "amount of time the temporary credentials are valid, min: 15m, max: 36h, or 12h when assuming a role and 1h when assuming one with temporary credentials (default 36h, or 1h when assuming a role)")
+	rootCmd.PersistentFlags().BoolVar(&clampDuration, "clamp-duration", false, "use the closest duration STS allows instead of failing when the duration is out of range")
+	rootCmd.PersistentFlags().StringVarP(&suffix, "suffix", "s", "permanent", "suffix to append to profile, used to find permanent credentials. results in <profile>-<suffix>")
+	rootCmd.PersistentFlags().StringVarP(&mfaSerial, "mfa", "m", "", "arn of your mfa device, e.g. `arn:aws:iam::<account-id>:mfa/<user>` uses one defined in the credentials file if exists and omitted")
+	rootCmd.PersistentFlags().StringVar(&token, "token", "", "current token code of your mfa device, otherwise read from AWS_MFA_TOKEN, the mfa_process command or the terminal")
+	rootCmd.PersistentFlags().StringVar(&roleArn, "role-arn", "", "arn of a role to assume with your mfa device, uses one defined in the permanent section if exists and omitted")
+	rootCmd.PersistentFlags().StringVar(&roleSessionName, "role-session-name", "", "name of the role session, defaults to aws-mfa-<timestamp>")
+	rootCmd.PersistentFlags().StringVar(&externalID, "external-id", "", "external id to pass when assuming the role")
 }
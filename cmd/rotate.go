@@ -0,0 +1,56 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"github.com/ngenator/aws-mfa/mfa"
+	"github.com/spf13/cobra"
+)
+
+var rotateDryRun bool
+
+// rotateCmd replaces the access key in the permanent section
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replaces the permanent AWS access key with a new one",
+	Long: `Creates a new access key using an MFA session, saves it to the permanent section and checks it works with
+GetCallerIdentity, then deactivates and deletes the old key. If anything fails before the old key is deactivated, it's
+put back and the new key is deleted. Use '--dry-run' to check the key can be rotated without changing anything.
+
+Your user needs to be allowed iam:ListAccessKeys, iam:CreateAccessKey, iam:UpdateAccessKey and iam:DeleteAccessKey
+on itself, and can have at most one access key before rotating.
+
+  aws-mfa rotate --profile work --dry-run`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		refresher, err := mfa.NewRefresher(config, mfa.NewSTS)
+		if err != nil {
+			return err
+		}
+
+		return refresher.Rotate(mfa.NewIAM, rotateDryRun)
+	},
+}
+
+func init() {
+	rotateCmd.Flags().BoolVar(&rotateDryRun, "dry-run", false, "check the access key can be rotated without changing anything")
+	rootCmd.AddCommand(rotateCmd)
+}
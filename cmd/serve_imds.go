@@ -0,0 +1,122 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ngenator/aws-mfa/mfa"
+	"github.com/spf13/cobra"
+)
+
+var (
+	imdsAddress string
+	imdsRole    string
+	imdsAllowV1 bool
+)
+
+// serveIMDSCmd emulates the EC2 instance metadata service
+var serveIMDSCmd = &cobra.Command{
+	Use:   "serve-imds",
+	Short: "Serves temporary AWS credentials like the EC2 instance metadata service",
+	Long: `Serves temporary AWS credentials from '/latest/meta-data/iam/security-credentials/<role>' like the EC2 instance
+metadata service, for tools that don't understand anything else. IMDSv2 session tokens are required, use
+'--allow-imdsv1' for tools that don't send them. Requests for any host other than the listen address or 169.254.169.254
+are rejected. Like the daemon, an MFA session is kept in memory and you're only prompted for a token code when it
+expires.
+
+  aws-mfa serve-imds --profile work --address 127.0.0.1:1338
+  AWS_EC2_METADATA_SERVICE_ENDPOINT=http://127.0.0.1:1338 aws s3 ls`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		refresher, err := mfa.NewRefresher(config, mfa.NewSTS)
+		if err != nil {
+			return err
+		}
+
+		daemon, err := mfa.NewDaemon(refresher)
+		if err != nil {
+			return err
+		}
+
+		// like the daemon, the credentials file is never written to
+		config.Close()
+
+		if _, err := daemon.Credentials(); err != nil {
+			return err
+		}
+
+		l, err := mfa.ListenLoopback(imdsAddress)
+		if err != nil {
+			return err
+		}
+
+		role := imdsRole
+		if role == "" {
+			role = defaultIMDSRole()
+		}
+		handler := mfa.NewIMDSHandler(daemon, role, l.Addr().String())
+		handler.AllowIMDSv1 = imdsAllowV1
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go daemon.Run(ctx)
+
+		server := &http.Server{Handler: handler}
+		errs := make(chan error, 1)
+		go func() { errs <- server.Serve(l) }()
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+
+		select {
+		case <-signals:
+		case err := <-errs:
+			return err
+		}
+
+		shutdown, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		return server.Shutdown(shutdown)
+	},
+}
+
+// defaultIMDSRole names the role after the one being assumed, or the profile
+func defaultIMDSRole() string {
+	if arn := config.Options.RoleARN; arn != "" {
+		return arn[strings.LastIndex(arn, "/")+1:]
+	}
+	return config.Options.Profile
+}
+
+func init() {
+	serveIMDSCmd.Flags().StringVar(&imdsAddress, "address", "127.0.0.1:1338", "loopback address to serve the metadata service on")
+	serveIMDSCmd.Flags().StringVar(&imdsRole, "role", "", "role name to serve the credentials as, defaults to the name of the assumed role or the profile")
+	serveIMDSCmd.Flags().BoolVar(&imdsAllowV1, "allow-imdsv1", false, "answer IMDSv1 requests that don't send a session token")
+	rootCmd.AddCommand(serveIMDSCmd)
+}
@@ -0,0 +1,82 @@
+// Copyright © 2018 Daniel Ng <dan@ngenator.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ngenator/aws-mfa/mfa"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statusOutput string
+	statusVerify bool
+)
+
+// statusCmd lists profiles and the state of their temporary credentials
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Lists profiles and when their temporary AWS credentials expire",
+	Long: `Lists every profile with a permanent section, or the ones given with '--profile', along with its MFA device,
+account, how long its temporary credentials have left and whether they're due to be refreshed. With '--verify', the
+temporary credentials are checked with GetCallerIdentity to find sessions that no longer work.
+
+  aws-mfa status --output json`,
+	Args: cobra.NoArgs,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		options, err := newOptions()
+		if err != nil {
+			return err
+		}
+
+		if cmd.Flags().Changed("profile") {
+			configs, invalidProfiles, err = options.ValidateEachProfile(profiles)
+		} else {
+			configs, invalidProfiles, err = options.ValidateEachProfile(nil)
+		}
+		return err
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// a broken profile is listed with its error instead of hiding the rest
+		statuses := make([]mfa.Status, 0, len(configs)+len(invalidProfiles))
+		for _, c := range configs {
+			refresher, err := mfa.NewRefresher(c, mfa.NewSTS)
+			if err != nil {
+				statuses = append(statuses, mfa.FailedStatus(c.Options.Profile, err))
+				continue
+			}
+			statuses = append(statuses, refresher.Status(statusVerify))
+		}
+		for _, result := range invalidProfiles {
+			statuses = append(statuses, mfa.FailedStatus(result.Profile, result.Err))
+		}
+
+		return mfa.WriteStatus(cmd.OutOrStdout(), statuses, statusOutput, statusVerify)
+	},
+}
+
+func init() {
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", mfa.TableOutput, fmt.Sprintf("output format, one of: %s", strings.Join(mfa.OutputFormats(), ", ")))
+	statusCmd.Flags().BoolVar(&statusVerify, "verify", false, "check the temporary credentials with GetCallerIdentity")
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,110 @@
+package mfa
+
+import (
+	"time"
+)
+
+// Result is the outcome of refreshing a single profile.
+type Result struct {
+	Profile   string
+	MFASerial string
+	Refreshed bool
+	Expires   time.Time
+	Err       error
+}
+
+// RefreshProfiles refreshes each config, prompting for a token code only once
+// per MFA device. Token codes can't be reused, so when several profiles share
+// a device and its access key a single MFA session is requested and roles are
+// assumed from it.
+func RefreshProfiles(configs []*Config, newSTS STSFactory) []Result {
+	logger := log.WithField("prefix", "batch")
+
+	results := make([]Result, len(configs))
+	refreshers := make([]*Refresher, len(configs))
+
+	// group the profiles that need refreshing by access key and device
+	type group struct {
+		key     string
+		members []int
+	}
+	var groups []*group
+	byKey := map[string]*group{}
+
+	for i, c := range configs {
+		results[i] = Result{Profile: c.Options.Profile, MFASerial: c.Options.MFASerial}
+
+		refresher, err := NewRefresher(c, newSTS)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		refreshers[i] = refresher
+
+		if ok, expires := refresher.NeedsRefresh(); !ok {
+			results[i].Expires = expires
+			continue
+		}
+
+		// profiles in other accounts often repeat the same access key, so
+		// group by the key rather than the section it's read from
+		id, _, err := c.AccessKey()
+		if err != nil {
+			// group it on its own, refreshing it reports the error
+			id = c.Source.Profile
+		}
+		key := id + "\x00" + c.Options.MFASerial
+		if c.Options.MFASerial == "" {
+			// nothing to prompt for, refresh it on its own
+			key = "\x00" + c.Options.Profile
+		}
+		if _, ok := byKey[key]; !ok {
+			byKey[key] = &group{key: key}
+			groups = append(groups, byKey[key])
+		}
+		byKey[key].members = append(byKey[key].members, i)
+	}
+
+	for _, g := range groups {
+		if len(g.members) == 1 {
+			i := g.members[0]
+			results[i].Err = refreshers[i].Refresh()
+			results[i].Refreshed = results[i].Err == nil
+			continue
+		}
+
+		// the session has to last as long as the profiles that use it
+		// directly, profiles that assume a role only need it briefly
+		duration := minDuration
+		for _, i := range g.members {
+			if o := configs[i].Options; !o.AssumesRole() && o.Duration > duration {
+				duration = o.Duration
+			}
+		}
+
+		first := refreshers[g.members[0]]
+		logger.WithField("mfa", first.Config.Options.MFASerial).Infof("Requesting an MFA session for %d profiles", len(g.members))
+
+		session, err := first.SessionToken(duration)
+		if err != nil {
+			for _, i := range g.members {
+				results[i].Err = err
+			}
+			continue
+		}
+
+		for _, i := range g.members {
+			results[i].Err = refreshers[i].RefreshFrom(session)
+			results[i].Refreshed = results[i].Err == nil
+		}
+	}
+
+	// read back when the refreshed credentials expire
+	for i, refresher := range refreshers {
+		if refresher != nil && results[i].Refreshed {
+			_, results[i].Expires = refresher.NeedsRefresh()
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,174 @@
+package mfa
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+const batchCredentials = `[default-permanent]
+aws_access_key_id     = AKIAPERMANENT
+aws_secret_access_key = permanent-secret
+mfa_serial            = ` + testSerial + `
+
+[dev-permanent]
+aws_access_key_id     = AKIAPERMANENT
+aws_secret_access_key = permanent-secret
+mfa_serial            = ` + testSerial + `
+role_arn              = arn:aws:iam::111111111111:role/dev
+
+[prod-permanent]
+role_arn       = arn:aws:iam::222222222222:role/prod
+source_profile = default-permanent
+
+[other-permanent]
+aws_access_key_id     = AKIAOTHER
+aws_secret_access_key = other-secret
+
+[stage-permanent]
+aws_access_key_id     = AKIASTAGE
+aws_secret_access_key = stage-secret
+mfa_serial            = arn:aws:iam::333333333333:mfa/stage
+
+[stage]
+aws_access_key_id     = ASIAEXISTING
+aws_secret_access_key = existing-secret
+aws_session_token     = existing-token
+expires               = `
+
+func TestRefreshProfiles(t *testing.T) {
+	path, cleanup := writeCredentials(t, batchCredentials+time.Now().Add(12*time.Hour).Format(time.RFC3339)+"\n")
+	defer cleanup()
+
+	configs, err := Options{
+		CredentialsFileLocation: path,
+		ProfileSuffix:           "permanent",
+		Token:                   "123456",
+	}.ValidateProfiles(nil)
+	if err != nil {
+		t.Fatalf("ValidateProfiles() error = %v", err)
+	}
+	defer configs[0].Close()
+
+	fake := &fakeSTS{}
+	results := RefreshProfiles(configs, fake.factory)
+
+	want := map[string]bool{"default": true, "dev": true, "prod": true, "other": true, "stage": false}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("%s: error = %v", result.Profile, result.Err)
+		}
+		if result.Refreshed != want[result.Profile] {
+			t.Errorf("%s: Refreshed = %v, want %v", result.Profile, result.Refreshed, want[result.Profile])
+		}
+		if result.Expires.Before(time.Now()) {
+			t.Errorf("%s: Expires = %v, want a time in the future", result.Profile, result.Expires)
+		}
+	}
+
+	// one session for the shared device, and one without a device
+	if got := len(fake.sessionTokenInputs); got != 2 {
+		t.Fatalf("GetSessionToken calls = %d, want 2", got)
+	}
+	if input := fake.sessionTokenInputs[0]; input.SerialNumber == nil || *input.SerialNumber != testSerial {
+		t.Errorf("SerialNumber = %v, want %s", input.SerialNumber, testSerial)
+	}
+	if input := fake.sessionTokenInputs[0]; *input.DurationSeconds != int64(defaultSessionDuration/time.Second) {
+		t.Errorf("DurationSeconds = %d, want %d", *input.DurationSeconds, int64(defaultSessionDuration/time.Second))
+	}
+
+	// roles are assumed from the session without a token code
+	if got := len(fake.assumeRoleInputs); got != 2 {
+		t.Fatalf("AssumeRole calls = %d, want 2", got)
+	}
+	for _, input := range fake.assumeRoleInputs {
+		if input.SerialNumber != nil || input.TokenCode != nil {
+			t.Errorf("AssumeRole(%s) SerialNumber = %v, TokenCode = %v, want nil", *input.RoleArn, input.SerialNumber, input.TokenCode)
+		}
+	}
+
+	saved, err := ini.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for profile, refreshed := range want {
+		key := "ASIAFAKEACCESSKEYID"
+		if !refreshed {
+			key = "ASIAEXISTING"
+		}
+		if got := saved.Section(profile).Key(accessKeyIDKey).String(); got != key {
+			t.Errorf("%s %s = %q, want %q", profile, accessKeyIDKey, got, key)
+		}
+	}
+}
+
+func TestRefreshProfilesFailure(t *testing.T) {
+	path, cleanup := writeCredentials(t, batchCredentials+time.Now().Add(12*time.Hour).Format(time.RFC3339)+"\n")
+	defer cleanup()
+
+	configs, err := Options{
+		CredentialsFileLocation: path,
+		ProfileSuffix:           "permanent",
+		Token:                   "123456",
+	}.ValidateProfiles([]string{"default", "dev", "stage"})
+	if err != nil {
+		t.Fatalf("ValidateProfiles() error = %v", err)
+	}
+	defer configs[0].Close()
+
+	fake := &fakeSTS{err: errors.New("AccessDenied")}
+	results := RefreshProfiles(configs, fake.factory)
+
+	// a failed session fails every profile that shares it, but not the
+	// profiles that didn't need refreshing
+	for _, result := range results {
+		if failed := result.Err != nil; failed != (result.Profile != "stage") {
+			t.Errorf("%s: error = %v", result.Profile, result.Err)
+		}
+	}
+	if got := fake.calls(); got != 1 {
+		t.Errorf("STS calls = %d, want 1", got)
+	}
+}
+
+func TestValidateEachProfile(t *testing.T) {
+	broken := "\n[broken-permanent]\naws_access_key_id = AKIABROKEN\n"
+	path, cleanup := writeCredentials(t, batchCredentials+time.Now().Add(12*time.Hour).Format(time.RFC3339)+"\n"+broken)
+	defer cleanup()
+
+	options := Options{
+		CredentialsFileLocation: path,
+		ProfileSuffix:           "permanent",
+		Token:                   "123456",
+	}
+
+	if _, err := options.ValidateProfiles(nil); err == nil {
+		t.Fatal("ValidateProfiles() error = nil, want the error of the broken profile")
+	}
+
+	// a broken profile is reported without stopping the others
+	configs, invalid, err := options.ValidateEachProfile(nil)
+	if err != nil {
+		t.Fatalf("ValidateEachProfile() error = %v", err)
+	}
+	defer configs[0].Close()
+
+	if len(configs) != 5 {
+		t.Errorf("got %d configs, want 5", len(configs))
+	}
+	if len(invalid) != 1 || invalid[0].Profile != "broken" || invalid[0].Err == nil {
+		t.Fatalf("invalid = %+v, want a failed result for broken", invalid)
+	}
+
+	fake := &fakeSTS{}
+	for _, result := range RefreshProfiles(configs, fake.factory) {
+		if result.Err != nil {
+			t.Errorf("%s: error = %v", result.Profile, result.Err)
+		}
+	}
+}
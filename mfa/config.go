@@ -0,0 +1,725 @@
+package mfa
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+	"github.com/sirupsen/logrus"
+)
+
+type ConfigValue struct {
+	Profile string
+	Section *ini.Section
+}
+
+// Settings is a list of sections that are searched in order for a key, the
+// first section that has the key wins.
+type Settings []ConfigValue
+
+// Key returns the first matching key and the profile it was found in.
+func (s Settings) Key(name string) (*ini.Key, string, bool) {
+	for _, v := range s {
+		if v.Section != nil && v.Section.HasKey(name) {
+			return v.Section.Key(name), v.Profile, true
+		}
+	}
+	return nil, "", false
+}
+
+// String returns the value of the first matching key, or an empty string.
+func (s Settings) String(name string) string {
+	if key, _, ok := s.Key(name); ok {
+		return key.String()
+	}
+	return ""
+}
+
+type Config struct {
+	Options Options
+
+	Permanent ConfigValue
+	Temporary ConfigValue
+
+	// Source contains the static credentials used to call STS. It is the
+	// permanent section unless a source_profile is configured.
+	Source ConfigValue
+
+	// Settings are the sections used to look up mfa_serial, role_arn, region
+	// and friends. The permanent section of the credentials file comes first,
+	// followed by the matching sections of the shared config file.
+	Settings Settings
+
+	CredentialsFile *ini.File
+	ConfigFile      *ini.File
+
+	store *credentialsStore
+
+	// output is the file the temporary section is in when it isn't the
+	// credentials file
+	output *outputFile
+}
+
+// credentialsStore is shared by the configs of every profile loaded from the
+// same credentials file
+type credentialsStore struct {
+	// data is what the credentials file contained when it was last read or
+	// written, changes are applied to it so the formatting is kept
+	data []byte
+
+	lock *FileLock
+
+	// encrypted is the key store of profiles with key_store = encrypted
+	encrypted *encryptedKeyStore
+
+	// outputs are the files temporary sections are written to instead of
+	// the credentials file, by path
+	outputs map[string]*outputFile
+}
+
+// outputFile is a file that only holds temporary sections, it's locked like
+// the credentials file while it's in use
+type outputFile struct {
+	path string
+	data []byte
+	file *ini.File
+	lock *FileLock
+}
+
+// output returns the output file at path, loading and locking it the first
+// time it's used. A missing file is created when it's saved.
+func (s *credentialsStore) output(path string, timeout time.Duration) (*outputFile, error) {
+	if output, ok := s.outputs[path]; ok {
+		return output, nil
+	}
+
+	lock, err := LockFile(path, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		lock.Unlock()
+		return nil, err
+	}
+	file, err := ini.Load(data)
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+
+	output := &outputFile{path: path, data: data, file: file, lock: lock}
+	s.outputs[path] = output
+	return output, nil
+}
+
+// unlock releases the locks on the credentials file and the output files
+func (s *credentialsStore) unlock() error {
+	err := s.lock.Unlock()
+	for _, output := range s.outputs {
+		if outputErr := output.lock.Unlock(); err == nil {
+			err = outputErr
+		}
+	}
+	return err
+}
+
+// saveINI atomically writes the changes made to file, which was loaded from
+// original, and returns what the file at path now contains. Nothing is written
+// when nothing changed.
+func saveINI(path string, original []byte, file *ini.File) ([]byte, error) {
+	data, err := patchINI(original, file)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(data, original) {
+		return original, nil
+	}
+
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Save atomically writes the changes made to the credentials file, and to
+// the file the temporary section is in when it's kept separately. Only the
+// keys and sections that changed are touched, everything else is written
+// back exactly as it was, and files that didn't change aren't written at all.
+func (c *Config) Save() error {
+	data, err := saveINI(c.Options.CredentialsFileLocation, c.store.data, c.CredentialsFile)
+	if err != nil {
+		return err
+	}
+	c.store.data = data
+
+	if c.output != nil {
+		data, err := saveINI(c.output.path, c.output.data, c.output.file)
+		if err != nil {
+			return err
+		}
+		c.output.data = data
+	}
+	return nil
+}
+
+// refreshing tells other processes waiting for the credentials file that a
+// refresh is in progress, so they wait for it rather than time out
+func (c *Config) refreshing() {
+	if err := c.store.lock.Refreshing(); err != nil {
+		log.WithField("prefix", "options").WithError(err).Debugln("Failed to mark the credentials file as refreshing")
+	}
+}
+
+// permanentReadOnly returns true when the temporary credentials are written
+// to an output file, the credentials file is left as it is then.
+func (c *Config) permanentReadOnly() bool {
+	return c.output != nil
+}
+
+// Close releases the lock on the credentials file, and any output files, for
+// every config that shares them. The config can't be saved safely afterwards.
+func (c *Config) Close() error {
+	return c.store.unlock()
+}
+
+// SharedConfigValues returns the settings that came from the shared config
+// file, these shouldn't be copied into the credentials file.
+func (c *Config) SharedConfigValues() Settings {
+	var values Settings
+	for _, v := range c.Settings {
+		if v.Section != c.Permanent.Section {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+type Options struct {
+	CredentialsFileLocation string
+	ConfigFileLocation      string
+	OutputFileLocation      string
+	ProcessCache            bool
+	KeyStoreFile            string
+	Profile                 string
+	ProfileSuffix           string
+	Duration                time.Duration
+	ClampDuration           bool
+	MFASerial               string
+	Token                   string
+	RoleARN                 string
+	RoleSessionName         string
+	ExternalID              string
+	RefreshBefore           Threshold
+	KeyAgeWarning           time.Duration
+	MaxKeyAge               time.Duration
+	MFAAttempts             int
+	ClearOnFailure          bool
+	SkipVerify              bool
+	LockTimeout             time.Duration
+	Force                   bool
+	Verbose                 bool
+}
+
+// AssumesRole returns true when the temporary credentials are generated by
+// assuming a role rather than by requesting a session token.
+func (o Options) AssumesRole() bool {
+	return o.RoleARN != ""
+}
+
+// expandHome replaces a leading ~ in path with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home := os.Getenv("HOME")
+	if runtime.GOOS == "windows" {
+		home = os.Getenv("USERPROFILE")
+	}
+
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+// permanentProfile returns the name of the permanent section of the profile,
+// the profile with the suffix appended
+func (o Options) permanentProfile() string {
+	if o.ProfileSuffix == "" {
+		return o.Profile
+	}
+	return o.Profile + "-" + o.ProfileSuffix
+}
+
+// sharedConfigSection returns the section for a profile within the shared
+// config file. Like the AWS CLI, only [default] and [profile <name>] are read,
+// a bare [<name>] section is ignored.
+func sharedConfigSection(file *ini.File, profile string) *ini.Section {
+	name := "profile " + profile
+	if profile == "default" {
+		name = profile
+	}
+
+	section, err := file.GetSection(name)
+	if err != nil {
+		return nil
+	}
+	return section
+}
+
+// Validate loads the config for a single profile.
+func (o Options) Validate() (*Config, error) {
+	configs, err := o.ValidateProfiles([]string{o.Profile})
+	if err != nil {
+		return nil, err
+	}
+	return configs[0], nil
+}
+
+// ValidateProfiles loads the credentials file once and returns the config of
+// each profile, they all share the file and its lock. If no profiles are
+// given, every profile with a permanent section is used. Problems with the
+// files or profiles are returned as a ConfigError where possible.
+func (o Options) ValidateProfiles(profiles []string) ([]*Config, error) {
+	lock, err := o.validateAndLock(profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	configs, err := o.withDefaults().load(lock, profiles)
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+// ValidateEachProfile is ValidateProfiles for refreshing several profiles at
+// once, a profile that fails to validate doesn't stop the others. The configs
+// of the valid profiles are returned along with a failed Result for each of
+// the others. An error is only returned when the files can't be loaded.
+func (o Options) ValidateEachProfile(profiles []string) ([]*Config, []Result, error) {
+	lock, err := o.validateAndLock(profiles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configs, invalid, err := o.withDefaults().loadEach(lock, profiles)
+	if err != nil {
+		lock.Unlock()
+		return nil, nil, err
+	}
+
+	return configs, invalid, nil
+}
+
+// validateAndLock logs the options and takes the lock on the credentials file
+func (o Options) validateAndLock(profiles []string) (*FileLock, error) {
+	logger := log.WithField("prefix", "options")
+
+	if o.Verbose {
+		log.SetLevel(logrus.DebugLevel)
+	}
+
+	logger.Debugln("Validating options")
+
+	logger.WithFields(logrus.Fields{
+		"--credentials":    o.CredentialsFileLocation,
+		"--config":         o.ConfigFileLocation,
+		"--output-file":    o.OutputFileLocation,
+		"--profile":        profiles,
+		"--suffix":         o.ProfileSuffix,
+		"--duration":       o.Duration,
+		"--clamp-duration": o.ClampDuration,
+		"--min-remaining":  o.RefreshBefore,
+		"--mfa":            o.MFASerial,
+		"--role-arn":       o.RoleARN,
+		"--force":          o.Force,
+		"--verbose":        o.Verbose,
+	}).Debugln("Using the following options")
+
+	return o.withDefaults().lockCredentials()
+}
+
+// withDefaults fills in the options that have a default
+func (o Options) withDefaults() Options {
+	if o.LockTimeout == 0 {
+		o.LockTimeout = DefaultLockTimeout
+	}
+	if o.MFAAttempts == 0 {
+		o.MFAAttempts = DefaultMFAAttempts
+	}
+	return o
+}
+
+// lockCredentials takes the lock on the credentials file, which is held until
+// the config is closed so concurrent refreshes don't clobber each other's
+// changes
+func (o Options) lockCredentials() (*FileLock, error) {
+	logger := log.WithField("prefix", "options")
+
+	// the lock file goes next to the credentials file, so make sure there
+	// is one before creating it
+	if _, err := os.Stat(o.CredentialsFileLocation); err != nil {
+		err = &MissingFileError{Path: o.CredentialsFileLocation, Err: err}
+		logger.WithError(err).Errorln("Failed to load the credentials file")
+		return nil, err
+	}
+
+	lock, err := LockFile(o.CredentialsFileLocation, o.LockTimeout)
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to lock the credentials file")
+		return nil, err
+	}
+	return lock, nil
+}
+
+// PermanentProfiles returns the profiles that have a permanent section in a
+// credentials file, in the order they appear.
+func PermanentProfiles(file *ini.File, suffix string) []string {
+	var profiles []string
+	for _, name := range file.SectionStrings() {
+		if strings.HasSuffix(name, "-"+suffix) && len(name) > len(suffix)+1 {
+			profiles = append(profiles, strings.TrimSuffix(name, "-"+suffix))
+		}
+	}
+	return profiles
+}
+
+// loadedFiles are the files profiles are loaded from
+type loadedFiles struct {
+	credentials *ini.File
+	config      *ini.File
+	store       *credentialsStore
+}
+
+// profiles returns every profile with a permanent section
+func (f loadedFiles) profiles(o Options) ([]string, error) {
+	profiles := PermanentProfiles(f.credentials, o.ProfileSuffix)
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no sections ending in -%s found in %s", o.ProfileSuffix, o.CredentialsFileLocation)
+	}
+	return profiles, nil
+}
+
+// loadFiles reads the credentials and config files
+func (o Options) loadFiles(lock *FileLock) (loadedFiles, error) {
+	logger := log.WithField("prefix", "options")
+
+	credentials, err := ioutil.ReadFile(o.CredentialsFileLocation)
+	if err != nil {
+		err = &MissingFileError{Path: o.CredentialsFileLocation, Err: err}
+		logger.WithError(err).Errorln("Failed to load the credentials file")
+		return loadedFiles{}, err
+	}
+
+	credentialsFile, err := ini.Load(credentials)
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to parse the credentials file")
+		return loadedFiles{}, fmt.Errorf("failed to parse the credentials file %s: %v", o.CredentialsFileLocation, err)
+	}
+
+	configFile := ini.Empty()
+	if o.ConfigFileLocation != "" {
+		// a missing config file is fine, not everyone has one
+		configFile, err = ini.LooseLoad(o.ConfigFileLocation)
+		if err != nil {
+			logger.WithError(err).Errorln("Failed to load the config file")
+			return loadedFiles{}, fmt.Errorf("failed to parse the config file %s: %v", o.ConfigFileLocation, err)
+		}
+	}
+
+	keyStoreFile := o.KeyStoreFile
+	if keyStoreFile == "" {
+		keyStoreFile = DefaultKeyStoreFile(o.CredentialsFileLocation)
+	}
+
+	return loadedFiles{
+		credentials: credentialsFile,
+		config:      configFile,
+		store: &credentialsStore{
+			data:      credentials,
+			lock:      lock,
+			encrypted: newEncryptedKeyStore(keyStoreFile),
+			outputs:   map[string]*outputFile{},
+		},
+	}, nil
+}
+
+func (o Options) load(lock *FileLock, profiles []string) ([]*Config, error) {
+	configs, invalid, err := o.loadEach(lock, profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(invalid) > 0 {
+		if len(configs) > 0 {
+			configs[0].Close()
+		}
+		return nil, invalid[0].Err
+	}
+
+	return configs, nil
+}
+
+// loadEach loads the config of each profile, the ones that fail to load are
+// returned as failed results instead. The lock is released when none load.
+func (o Options) loadEach(lock *FileLock, profiles []string) ([]*Config, []Result, error) {
+	logger := log.WithField("prefix", "options")
+
+	files, err := o.loadFiles(lock)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(profiles) == 0 {
+		if profiles, err = files.profiles(o); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	configs := make([]*Config, 0, len(profiles))
+	var invalid []Result
+	for _, profile := range profiles {
+		options := o
+		options.Profile = profile
+
+		config, err := options.profileConfig(files.credentials, files.config, files.store)
+		if err == nil {
+			if problems := config.check(); len(problems) > 0 {
+				err = problems[0]
+				logger.WithError(err).WithField("profile", profile).Errorln("Invalid profile")
+			}
+		}
+		if err != nil {
+			invalid = append(invalid, Result{Profile: profile, Err: err})
+			continue
+		}
+
+		configs = append(configs, config)
+	}
+
+	if len(configs) == 0 {
+		files.store.unlock()
+	}
+
+	return configs, invalid, nil
+}
+
+func (o Options) profileConfig(credentialsFile, configFile *ini.File, store *credentialsStore) (*Config, error) {
+	logger := log.WithFields(logrus.Fields{"prefix": "options", "profile": o.Profile})
+
+	permanentProfile := o.permanentProfile()
+
+	if permanentProfile == o.Profile {
+		err := &SameProfileError{Profile: o.Profile}
+		logger.WithError(err).Errorln("Invalid profile")
+		return nil, err
+	}
+
+	perm, err := credentialsFile.GetSection(permanentProfile)
+	if err != nil {
+		missing := &MissingSectionError{Profile: o.Profile, Section: permanentProfile, Suffix: o.ProfileSuffix, Path: o.CredentialsFileLocation}
+		if section, err := credentialsFile.GetSection(o.Profile); err == nil {
+			missing.Migratable = section.HasKey(accessKeyIDKey) && !section.HasKey(sessionTokenKey)
+		}
+		err = missing
+		logger.WithError(err).Errorln("Failed to read permanent credentials section")
+		return nil, err
+	}
+
+	// the credentials file takes precedence over the config file
+	settings := Settings{{Profile: permanentProfile, Section: perm}}
+	for _, profile := range []string{permanentProfile, o.Profile} {
+		if section := sharedConfigSection(configFile, profile); section != nil {
+			logger.WithField("section", section.Name()).Debugln("Using settings from the config file")
+			settings = append(settings, ConfigValue{Profile: profile, Section: section})
+		}
+	}
+
+	if o.RoleARN == "" {
+		o.RoleARN = settings.String(roleArnKey)
+	}
+
+	source := ConfigValue{
+		Profile: permanentProfile,
+		Section: perm,
+	}
+
+	if o.AssumesRole() {
+		if o.ExternalID == "" {
+			o.ExternalID = settings.String(externalIDKey)
+		}
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = settings.String(roleSessionNameKey)
+		}
+
+		if sourceProfile := settings.String(sourceProfileKey); sourceProfile != "" && sourceProfile != permanentProfile {
+			if sourceProfile == o.Profile {
+				err := &SameProfileError{Profile: o.Profile, Key: sourceProfileKey}
+				logger.WithError(err).Errorln("Invalid source profile")
+				return nil, err
+			}
+
+			section, err := credentialsFile.GetSection(sourceProfile)
+			if err != nil {
+				err = &MissingSectionError{Profile: o.Profile, Section: sourceProfile, Path: o.CredentialsFileLocation, SourceProfile: true}
+				logger.WithError(err).Errorln("Failed to read source profile section")
+				return nil, err
+			}
+			source = ConfigValue{
+				Profile: sourceProfile,
+				Section: section,
+			}
+			settings = append(settings, source)
+			if section := sharedConfigSection(configFile, sourceProfile); section != nil {
+				settings = append(settings, ConfigValue{Profile: sourceProfile, Section: section})
+			}
+		}
+	}
+
+	if o.MFASerial == "" {
+		o.MFASerial = settings.String(mfaSerialKey)
+	}
+
+	durationSource := "--duration"
+	if key, profile, ok := settings.Key(durationSecondsKey); ok && o.Duration == 0 {
+		seconds, err := key.Int64()
+		if err != nil {
+			logger.WithError(err).WithField("profile", profile).Errorln("Failed to parse duration_seconds")
+			return nil, err
+		}
+		o.Duration = time.Duration(seconds) * time.Second
+		durationSource = fmt.Sprintf("duration_seconds in [%s]", profile)
+	}
+	if o.Duration == 0 {
+		if o.AssumesRole() {
+			o.Duration = defaultRoleDuration
+		} else {
+			o.Duration = defaultSessionDuration
+		}
+	}
+
+	// check the duration now rather than after prompting for a token code,
+	// roles assumed with temporary credentials are limited to an hour
+	chained := o.AssumesRole() && source.Section.HasKey(sessionTokenKey)
+	if o.Duration, err = checkDuration(o.Duration, durationSource, o.AssumesRole(), chained, o.ClampDuration); err != nil {
+		logger.WithError(err).Errorln("Invalid duration")
+		return nil, err
+	}
+
+	if key, profile, ok := settings.Key(refreshBeforeKey); ok && o.RefreshBefore.IsZero() {
+		o.RefreshBefore, err = ParseThreshold(key.String())
+		if err != nil {
+			logger.WithError(err).WithField("profile", profile).Errorln("Failed to parse refresh_before")
+			return nil, err
+		}
+	}
+	if o.RefreshBefore.IsZero() {
+		o.RefreshBefore = DefaultThreshold
+	}
+
+	for _, age := range []struct {
+		name  string
+		value *time.Duration
+	}{{keyAgeWarningKey, &o.KeyAgeWarning}, {maxKeyAgeKey, &o.MaxKeyAge}} {
+		if key, profile, ok := settings.Key(age.name); ok && *age.value == 0 {
+			*age.value, err = ParseAge(key.String())
+			if err != nil {
+				logger.WithError(err).WithField("profile", profile).Errorf("Failed to parse %s", age.name)
+				return nil, err
+			}
+		}
+	}
+	if o.KeyAgeWarning == 0 {
+		o.KeyAgeWarning = DefaultKeyAgeWarning
+	}
+
+	if o.OutputFileLocation == "" {
+		o.OutputFileLocation = settings.String(tempCredentialsFileKey)
+	}
+	if o.OutputFileLocation == "" && o.ProcessCache {
+		o.OutputFileLocation = DefaultProcessCacheFile(o.CredentialsFileLocation)
+	}
+	o.OutputFileLocation = expandHome(o.OutputFileLocation)
+
+	if o.ProcessCache {
+		// the CLI and SDKs read the credentials file before running
+		// credential_process, so a temporary section there would be used
+		// instead of asking aws-mfa once it expires
+		if o.OutputFileLocation != "" && sameFile(o.OutputFileLocation, o.CredentialsFileLocation) {
+			err := fmt.Errorf("the temporary credentials of %s can't be written to the credentials file for credential_process, point --output-file or %s at another file", o.Profile, tempCredentialsFileKey)
+			logger.WithError(err).Errorln("Invalid output file")
+			return nil, err
+		}
+		if section, err := credentialsFile.GetSection(o.Profile); err == nil && section.HasKey(accessKeyIDKey) {
+			logger.Warnf("The [%s] section of the credentials file is read before credential_process is run, remove it or its access key so aws-mfa is asked for credentials", o.Profile)
+		}
+	}
+
+	tempFile := credentialsFile
+	var output *outputFile
+	if o.OutputFileLocation != "" && !sameFile(o.OutputFileLocation, o.CredentialsFileLocation) {
+		logger.WithField("file", o.OutputFileLocation).Debugln("Writing the temporary credentials to a separate file")
+		output, err = store.output(o.OutputFileLocation, o.LockTimeout)
+		if err != nil {
+			logger.WithError(err).Errorln("Failed to load the output file")
+			return nil, err
+		}
+		tempFile = output.file
+	}
+
+	temp, err := tempFile.GetSection(o.Profile)
+	if err != nil {
+		logger.Debugln("Failed to read temporary credentials section, creating one")
+		temp = tempFile.Section(o.Profile)
+	}
+
+	if o.AssumesRole() && o.RoleSessionName == "" {
+		o.RoleSessionName = fmt.Sprintf("aws-mfa-%d", time.Now().Unix())
+	}
+
+	return &Config{
+		Options: o,
+
+		Permanent: ConfigValue{
+			Profile: permanentProfile,
+			Section: perm,
+		},
+		Temporary: ConfigValue{
+			Profile: o.Profile,
+			Section: temp,
+		},
+		Source:          source,
+		Settings:        settings,
+		CredentialsFile: credentialsFile,
+		ConfigFile:      configFile,
+		store:           store,
+		output:          output,
+	}, nil
+}
+
+// sameFile returns true if both paths name the same file
+func sameFile(a, b string) bool {
+	if infoA, err := os.Stat(a); err == nil {
+		if infoB, err := os.Stat(b); err == nil {
+			return os.SameFile(infoA, infoB)
+		}
+	}
+
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,195 @@
+package mfa
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+func TestSharedConfigSection(t *testing.T) {
+	file, err := ini.Load([]byte(`[default]
+region = us-east-1
+
+[profile work]
+region = eu-west-1
+
+[personal]
+region = us-west-2
+
+[profile default]
+region = ap-south-1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		profile string
+		want    string
+	}{
+		{"default", "default"},
+		{"work", "profile work"},
+		{"personal", ""},
+		{"missing", ""},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if section := sharedConfigSection(file, tt.profile); section != nil {
+			got = section.Name()
+		}
+		if got != tt.want {
+			t.Errorf("sharedConfigSection(%q) = [%s], want [%s]", tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestConfigFilePrecedence(t *testing.T) {
+	const (
+		configSerial      = "arn:aws:iam::123456789012:mfa/config"
+		credentialsSerial = "arn:aws:iam::123456789012:mfa/credentials"
+		flagSerial        = "arn:aws:iam::123456789012:mfa/flag"
+
+		configRole      = "arn:aws:iam::210987654321:role/config"
+		credentialsRole = "arn:aws:iam::210987654321:role/credentials"
+		flagRole        = "arn:aws:iam::210987654321:role/flag"
+	)
+
+	tests := []struct {
+		name        string
+		key         string
+		config      string
+		credentials string
+		options     Options
+		want        func(c *Config) string
+		wantValue   string
+	}{
+		{
+			name:      "mfa_serial from the config file",
+			key:       mfaSerialKey,
+			config:    configSerial,
+			want:      func(c *Config) string { return c.Options.MFASerial },
+			wantValue: configSerial,
+		},
+		{
+			name:        "mfa_serial from the credentials file",
+			key:         mfaSerialKey,
+			config:      configSerial,
+			credentials: credentialsSerial,
+			want:        func(c *Config) string { return c.Options.MFASerial },
+			wantValue:   credentialsSerial,
+		},
+		{
+			name:        "mfa_serial from --mfa",
+			key:         mfaSerialKey,
+			config:      configSerial,
+			credentials: credentialsSerial,
+			options:     Options{MFASerial: flagSerial},
+			want:        func(c *Config) string { return c.Options.MFASerial },
+			wantValue:   flagSerial,
+		},
+		{
+			name:      "role_arn from the config file",
+			key:       roleArnKey,
+			config:    configRole,
+			want:      func(c *Config) string { return c.Options.RoleARN },
+			wantValue: configRole,
+		},
+		{
+			name:        "role_arn from the credentials file",
+			key:         roleArnKey,
+			config:      configRole,
+			credentials: credentialsRole,
+			want:        func(c *Config) string { return c.Options.RoleARN },
+			wantValue:   credentialsRole,
+		},
+		{
+			name:        "role_arn from --role-arn",
+			key:         roleArnKey,
+			config:      configRole,
+			credentials: credentialsRole,
+			options:     Options{RoleARN: flagRole},
+			want:        func(c *Config) string { return c.Options.RoleARN },
+			wantValue:   flagRole,
+		},
+		{
+			name:      "region from the config file",
+			key:       regionKey,
+			config:    "eu-west-1",
+			want:      func(c *Config) string { return c.Settings.String(regionKey) },
+			wantValue: "eu-west-1",
+		},
+		{
+			name:        "region from the credentials file",
+			key:         regionKey,
+			config:      "eu-west-1",
+			credentials: "us-west-2",
+			want:        func(c *Config) string { return c.Settings.String(regionKey) },
+			wantValue:   "us-west-2",
+		},
+		{
+			name:      "duration_seconds from the config file",
+			key:       durationSecondsKey,
+			config:    "3600",
+			want:      func(c *Config) string { return c.Options.Duration.String() },
+			wantValue: "1h0m0s",
+		},
+		{
+			name:        "duration_seconds from the credentials file",
+			key:         durationSecondsKey,
+			config:      "3600",
+			credentials: "7200",
+			want:        func(c *Config) string { return c.Options.Duration.String() },
+			wantValue:   "2h0m0s",
+		},
+		{
+			name:        "duration_seconds from --duration",
+			key:         durationSecondsKey,
+			config:      "3600",
+			credentials: "7200",
+			options:     Options{Duration: 3 * time.Hour},
+			want:        func(c *Config) string { return c.Options.Duration.String() },
+			wantValue:   "3h0m0s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extra := ""
+			if tt.credentials != "" {
+				extra = tt.key + " = " + tt.credentials
+			}
+			path, cleanup := writeCredentials(t, `[work-permanent]
+aws_access_key_id     = AKIAPERMANENT
+aws_secret_access_key = permanent-secret
+`+extra+"\n")
+			defer cleanup()
+
+			// the bare section is ignored, like the AWS CLI does
+			configPath := filepath.Join(filepath.Dir(path), "config")
+			config := "[profile work]\n" + tt.key + " = " + tt.config + "\n\n[work]\n" + tt.key + " = ignored\n"
+			if err := ioutil.WriteFile(configPath, []byte(config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			options := tt.options
+			options.CredentialsFileLocation = path
+			options.ConfigFileLocation = configPath
+			options.Profile = "work"
+			options.ProfileSuffix = "permanent"
+
+			c, err := options.Validate()
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			defer c.Close()
+
+			if got := tt.want(c); got != tt.wantValue {
+				t.Errorf("%s = %q, want %q", tt.key, got, tt.wantValue)
+			}
+		})
+	}
+}
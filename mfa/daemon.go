@@ -0,0 +1,352 @@
+package mfa
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// daemonRetryInterval is how long the daemon waits after a failed refresh
+	daemonRetryInterval = time.Minute
+
+	// daemonWaitTimeout is how long a request waits for a refresh that's in
+	// progress, which can be stuck on the token code prompt
+	daemonWaitTimeout = 2 * time.Minute
+)
+
+// ContainerCredentials is the document served to the SDKs when they're given
+// AWS_CONTAINER_CREDENTIALS_FULL_URI.
+type ContainerCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+}
+
+// Daemon keeps an MFA session in memory and derives short lived credentials
+// from it, so a token code is only needed when the session expires.
+type Daemon struct {
+	log       *logrus.Entry
+	refresher *Refresher
+
+	// SessionDuration is how long each MFA session lasts
+	SessionDuration time.Duration
+
+	// RefreshBefore is how much of their lifetime credentials need to have
+	// left to not be replaced, the refresh threshold of the profile
+	RefreshBefore Threshold
+
+	now         func() time.Time
+	waitTimeout time.Duration
+
+	mu                sync.Mutex
+	session           *sts.Credentials
+	sessionIssued     time.Time
+	credentials       *sts.Credentials
+	credentialsIssued time.Time
+	refreshing        *daemonRefresh
+}
+
+// daemonRefresh is a refresh in progress, its result is shared with the
+// requests that wait for it
+type daemonRefresh struct {
+	done        chan struct{}
+	credentials *sts.Credentials
+	err         error
+}
+
+// NewDaemon creates a daemon for the refresher's profile. Roles are assumed
+// from a session lasting the default session duration, otherwise the session
+// is used as is and lasts the duration of the profile. Credentials are
+// replaced when they reach the refresh threshold of the profile.
+func NewDaemon(r *Refresher) (*Daemon, error) {
+	duration := r.Config.Options.Duration
+	refreshBefore := r.Config.Options.RefreshBefore
+	if now := time.Now(); refreshBefore.Remaining(now, now.Add(duration)) >= duration {
+		return nil, fmt.Errorf("credentials lasting %s can't be refreshed %s before they expire", duration, refreshBefore)
+	}
+
+	sessionDuration := duration
+	if r.Config.Options.AssumesRole() {
+		sessionDuration = defaultSessionDuration
+	}
+
+	return &Daemon{
+		log:             log.WithFields(logrus.Fields{"prefix": "daemon", "profile": r.Config.Options.Profile}),
+		refresher:       r,
+		SessionDuration: sessionDuration,
+		RefreshBefore:   refreshBefore,
+		now:             time.Now,
+		waitTimeout:     daemonWaitTimeout,
+	}, nil
+}
+
+// refreshAt returns when credentials issued at the given time need to be
+// replaced
+func (d *Daemon) refreshAt(credentials *sts.Credentials, issued time.Time) time.Time {
+	expires := aws.TimeValue(credentials.Expiration)
+	return expires.Add(-d.RefreshBefore.Remaining(issued, expires))
+}
+
+// expiring returns true if credentials are missing or need to be replaced
+func (d *Daemon) expiring(credentials *sts.Credentials, issued time.Time) bool {
+	return credentials == nil || !d.now().Before(d.refreshAt(credentials, issued))
+}
+
+// Credentials returns the current credentials, deriving new ones from the MFA
+// session when they're about to expire. A token code is only requested when
+// the session itself is about to expire. Only one refresh runs at a time, while
+// it waits on the token code other requests get the current credentials until
+// they expire, and then wait for the refresh to finish.
+func (d *Daemon) Credentials() (*sts.Credentials, error) {
+	d.mu.Lock()
+	if !d.expiring(d.credentials, d.credentialsIssued) {
+		defer d.mu.Unlock()
+		return d.credentials, nil
+	}
+
+	if refresh := d.refreshing; refresh != nil {
+		credentials := d.credentials
+		d.mu.Unlock()
+
+		if credentials != nil && d.now().Before(aws.TimeValue(credentials.Expiration)) {
+			return credentials, nil
+		}
+
+		select {
+		case <-refresh.done:
+			return refresh.credentials, refresh.err
+		case <-time.After(d.waitTimeout):
+			return nil, errors.New("timed out waiting for the credentials to be refreshed")
+		}
+	}
+
+	refresh := &daemonRefresh{done: make(chan struct{})}
+	d.refreshing = refresh
+	d.mu.Unlock()
+
+	refresh.credentials, refresh.err = d.refresh()
+
+	d.mu.Lock()
+	d.refreshing = nil
+	d.mu.Unlock()
+	close(refresh.done)
+
+	return refresh.credentials, refresh.err
+}
+
+// refresh derives new credentials from the MFA session, requesting a new
+// session first if it's about to expire. It's only run by one request at a
+// time, without holding the lock.
+func (d *Daemon) refresh() (*sts.Credentials, error) {
+	d.mu.Lock()
+	session, sessionIssued := d.session, d.sessionIssued
+	d.mu.Unlock()
+
+	if d.expiring(session, sessionIssued) {
+		d.log.Infoln("Requesting a new MFA session")
+
+		sessionIssued = d.now()
+		var err error
+		if session, err = d.refresher.SessionToken(d.SessionDuration); err != nil {
+			return nil, err
+		}
+
+		d.mu.Lock()
+		d.session, d.sessionIssued = session, sessionIssued
+		d.mu.Unlock()
+	}
+
+	issued := d.now()
+	credentials, err := d.refresher.credentialsFrom(session)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.credentials, d.credentialsIssued = credentials, issued
+	d.mu.Unlock()
+
+	d.log.WithField("expires", aws.TimeValue(credentials.Expiration).Sub(d.now()).Round(time.Second)).Infoln("Refreshed credentials")
+
+	return credentials, nil
+}
+
+// Run refreshes the credentials ahead of time until ctx is done, so requests
+// don't have to wait on STS or a token code.
+func (d *Daemon) Run(ctx context.Context) {
+	for {
+		wait := daemonRetryInterval
+		if _, err := d.Credentials(); err != nil {
+			d.log.WithError(err).Errorln("Failed to refresh credentials, retrying in", wait)
+		} else if until := d.nextRefresh().Sub(d.now()); until > 0 {
+			wait = until
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// nextRefresh returns when the current credentials need to be replaced
+func (d *Daemon) nextRefresh() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.refreshAt(d.credentials, d.credentialsIssued)
+}
+
+// Serve refreshes the credentials in the background and serves them on the
+// agent socket, and the container listener if it's not nil, until ctx is done
+// or serving fails.
+func (d *Daemon) Serve(ctx context.Context, agent, container net.Listener, token string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go d.Run(ctx)
+
+	errs := make(chan error, 2)
+	go func() { errs <- d.ServeAgent(agent) }()
+
+	var server *http.Server
+	if container != nil {
+		server = &http.Server{Handler: d.ContainerHandler(token)}
+		go func() { errs <- server.Serve(container) }()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		d.log.Infoln("Shutting down")
+	case err = <-errs:
+		d.log.WithError(err).Errorln("Stopped serving credentials")
+	}
+
+	agent.Close()
+	if server != nil {
+		shutdown, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		server.Shutdown(shutdown)
+	}
+
+	return err
+}
+
+// ListenAgent listens on a unix socket that only the current user can use,
+// replacing a stale socket left behind by a daemon that didn't shut down.
+// Anything else at path is left alone.
+func ListenAgent(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %s", path)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s already exists and isn't a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	l, err := listenUnix(path)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(logrus.Fields{"prefix": "daemon", "socket": path}).Infoln("Serving credentials")
+	return l, nil
+}
+
+// ListenLoopback listens on address, which has to be a loopback address since
+// the credentials are served over plain HTTP.
+func ListenLoopback(address string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%s isn't a loopback address", address)
+	}
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(logrus.Fields{"prefix": "daemon", "address": l.Addr().String()}).Infoln("Serving container credentials")
+	return l, nil
+}
+
+// ServeAgent writes the credentials in the credential_process format to each
+// connection accepted on l, until l is closed.
+func (d *Daemon) ServeAgent(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			credentials, err := d.Credentials()
+			if err != nil {
+				d.log.WithError(err).Errorln("Failed to get credentials for an agent connection")
+				return
+			}
+
+			json.NewEncoder(conn).Encode(newProcessCredentials(credentials))
+		}()
+	}
+}
+
+// ContainerHandler serves the credentials in the format of the ECS container
+// credentials endpoint. Requests must send token in the Authorization header,
+// as the SDKs do with AWS_CONTAINER_AUTHORIZATION_TOKEN.
+func (d *Daemon) ContainerHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(token)) != 1 {
+			d.log.WithField("remote", req.RemoteAddr).Warnln("Rejected a request with an invalid authorization token")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		credentials, err := d.Credentials()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ContainerCredentials{
+			AccessKeyID:     aws.StringValue(credentials.AccessKeyId),
+			SecretAccessKey: aws.StringValue(credentials.SecretAccessKey),
+			Token:           aws.StringValue(credentials.SessionToken),
+			Expiration:      aws.TimeValue(credentials.Expiration).UTC().Format(time.RFC3339),
+		})
+	})
+}
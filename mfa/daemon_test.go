@@ -0,0 +1,324 @@
+package mfa
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func newTestDaemon(t *testing.T, extra string) (*Daemon, *fakeSTS, func()) {
+	path, cleanup := writeCredentials(t, permanentSection("mfa_serial = "+testSerial+"\n"+extra))
+
+	config, err := Options{
+		CredentialsFileLocation: path,
+		Profile:                 "default",
+		ProfileSuffix:           "permanent",
+		Token:                   "123456",
+	}.Validate()
+	if err != nil {
+		cleanup()
+		t.Fatalf("Validate() error = %v", err)
+	}
+	config.Close()
+
+	fake := &fakeSTS{}
+	refresher, _ := NewRefresher(config, fake.factory)
+
+	daemon, err := NewDaemon(refresher)
+	if err != nil {
+		cleanup()
+		t.Fatalf("NewDaemon() error = %v", err)
+	}
+
+	return daemon, fake, cleanup
+}
+
+func TestDaemonCredentials(t *testing.T) {
+	daemon, fake, cleanup := newTestDaemon(t, "role_arn = arn:aws:iam::210987654321:role/admin")
+	defer cleanup()
+
+	now := time.Now()
+	daemon.now = func() time.Time { return now }
+	fake.now = daemon.now
+
+	steps := []struct {
+		name         string
+		after        time.Duration
+		wantSessions int
+		wantRoles    int
+	}{
+		{"prompts for the first session", 0, 1, 1},
+		{"reuses unexpired credentials", 20 * time.Minute, 1, 1},
+		{"assumes the role again at half its lifetime", 40 * time.Minute, 1, 2},
+		{"prompts again an hour before the session expires", defaultSessionDuration - 50*time.Minute, 2, 3},
+	}
+
+	for _, step := range steps {
+		now = time.Now().Add(step.after)
+
+		credentials, err := daemon.Credentials()
+		if err != nil {
+			t.Fatalf("%s: Credentials() error = %v", step.name, err)
+		}
+		if credentials.Expiration.Before(now.Add(defaultRoleDuration / 2)) {
+			t.Errorf("%s: credentials expire at %v", step.name, credentials.Expiration)
+		}
+		if got := len(fake.sessionTokenInputs); got != step.wantSessions {
+			t.Errorf("%s: GetSessionToken calls = %d, want %d", step.name, got, step.wantSessions)
+		}
+		if got := len(fake.assumeRoleInputs); got != step.wantRoles {
+			t.Errorf("%s: AssumeRole calls = %d, want %d", step.name, got, step.wantRoles)
+		}
+	}
+
+	for _, input := range fake.assumeRoleInputs {
+		if input.SerialNumber != nil || input.TokenCode != nil {
+			t.Errorf("AssumeRole SerialNumber = %v, TokenCode = %v, want nil", input.SerialNumber, input.TokenCode)
+		}
+	}
+}
+
+// blockingToken waits for a token code to be sent on it, like a prompt
+type blockingToken struct {
+	prompted chan struct{}
+	code     chan string
+}
+
+func (t blockingToken) Token(serial string) (string, error) {
+	t.prompted <- struct{}{}
+	return <-t.code, nil
+}
+
+func TestDaemonCredentialsPrompt(t *testing.T) {
+	daemon, fake, cleanup := newTestDaemon(t, "")
+	defer cleanup()
+
+	token := blockingToken{prompted: make(chan struct{}), code: make(chan string)}
+	daemon.refresher.tokens = token
+
+	prompt := make(chan error)
+	go func() {
+		_, err := daemon.Credentials()
+		prompt <- err
+	}()
+	<-token.prompted
+
+	// other requests aren't stuck behind the prompt
+	daemon.waitTimeout = 10 * time.Millisecond
+	if _, err := daemon.Credentials(); err == nil {
+		t.Error("Credentials() error = nil, want a timeout while the prompt is waiting")
+	}
+
+	daemon.waitTimeout = daemonWaitTimeout
+	waiter := make(chan error)
+	go func() {
+		_, err := daemon.Credentials()
+		waiter <- err
+	}()
+
+	token.code <- "123456"
+	for _, done := range []chan error{prompt, waiter} {
+		if err := <-done; err != nil {
+			t.Errorf("Credentials() error = %v", err)
+		}
+	}
+	if fake.calls() != 1 {
+		t.Errorf("STS calls = %d, want 1, waiting requests should share the refresh", fake.calls())
+	}
+
+	// until they expire, the current credentials are served during a refresh
+	now := time.Now().Add(defaultSessionDuration - time.Minute)
+	daemon.now = func() time.Time { return now }
+	go daemon.Credentials()
+	<-token.prompted
+
+	daemon.waitTimeout = 10 * time.Millisecond
+	if _, err := daemon.Credentials(); err != nil {
+		t.Errorf("Credentials() error = %v, want the current credentials during the prompt", err)
+	}
+	token.code <- "123456"
+}
+
+func TestNewDaemonRefreshBefore(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t, "refresh_before = 2h")
+	defer cleanup()
+
+	if want := (Threshold{Duration: 2 * time.Hour}); daemon.RefreshBefore != want {
+		t.Errorf("RefreshBefore = %v, want refresh_before of the profile, %v", daemon.RefreshBefore, want)
+	}
+
+	daemon.refresher.Config.Options.RefreshBefore = Threshold{Duration: defaultSessionDuration}
+	if _, err := NewDaemon(daemon.refresher); err == nil {
+		t.Error("NewDaemon() error = nil, want an error when refreshing before the credentials are issued")
+	}
+}
+
+func TestDaemonContainerHandler(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t, "")
+	defer cleanup()
+
+	server := httptest.NewServer(daemon.ContainerHandler("secret"))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		wantStatus    int
+	}{
+		{"rejects a missing token", http.MethodGet, "", http.StatusUnauthorized},
+		{"rejects the wrong token", http.MethodGet, "wrong", http.StatusUnauthorized},
+		{"rejects other methods", http.MethodPost, "secret", http.StatusMethodNotAllowed},
+		{"serves credentials", http.MethodGet, "secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			var credentials ContainerCredentials
+			if err := json.NewDecoder(resp.Body).Decode(&credentials); err != nil {
+				t.Fatal(err)
+			}
+			if credentials.AccessKeyID != "ASIAFAKEACCESSKEYID" || credentials.Token != "fake-session-token" {
+				t.Errorf("credentials = %+v", credentials)
+			}
+			if _, err := time.Parse(time.RFC3339, credentials.Expiration); err != nil {
+				t.Errorf("Expiration = %q: %v", credentials.Expiration, err)
+			}
+		})
+	}
+}
+
+func TestDaemonServeAgent(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t, "")
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "aws-mfa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Skipf("unix sockets aren't supported: %v", err)
+	}
+	defer l.Close()
+	go daemon.ServeAgent(l)
+
+	// every connection gets the same credentials
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("unix", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var credentials ProcessCredentials
+		err = json.NewDecoder(conn).Decode(&credentials)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if credentials.Version != 1 || credentials.AccessKeyID != "ASIAFAKEACCESSKEYID" || credentials.SessionToken != "fake-session-token" {
+			t.Errorf("credentials = %+v", credentials)
+		}
+	}
+}
+
+func TestDaemonServe(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t, "")
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "aws-mfa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "agent.sock")
+	agent, err := ListenAgent(socket)
+	if err != nil {
+		t.Skipf("unix sockets aren't supported: %v", err)
+	}
+	if _, err := ListenAgent(socket); err == nil {
+		t.Error("ListenAgent() error = nil, want an error while another daemon is listening")
+	}
+
+	other := filepath.Join(dir, "other")
+	if err := ioutil.WriteFile(other, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListenAgent(other); err == nil {
+		t.Error("ListenAgent() error = nil, want an error for a file that isn't a socket")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("ListenAgent() removed a file that isn't a socket: %v", err)
+	}
+
+	if info, err := os.Stat(socket); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		t.Errorf("socket mode = %v, want only the owner to have access", info.Mode().Perm())
+	}
+
+	if _, err := ListenLoopback("0.0.0.0:0"); err == nil {
+		t.Error("ListenLoopback(0.0.0.0:0) error = nil, want an error for a non loopback address")
+	}
+	container, err := ListenLoopback("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- daemon.Serve(ctx, agent, container, "secret") }()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+container.Addr().String()+"/", nil)
+	req.Header.Set("Authorization", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve() didn't return after the context was cancelled")
+	}
+
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket wasn't removed on shutdown: %v", err)
+	}
+}
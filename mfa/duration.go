@@ -0,0 +1,78 @@
+package mfa
+
+import (
+	"fmt"
+	"time"
+)
+
+// Limits STS puts on the duration of temporary credentials
+const (
+	minDuration            = 15 * time.Minute
+	maxSessionDuration     = 36 * time.Hour
+	maxRoleDuration        = 12 * time.Hour
+	maxChainedRoleDuration = time.Hour
+)
+
+// DurationError is returned when the requested duration is outside the range
+// STS allows for the kind of credentials being requested.
+type DurationError struct {
+	Duration time.Duration
+	Min, Max time.Duration
+
+	// Kind describes the credentials, e.g. "session tokens"
+	Kind string
+
+	// Source is where the duration came from, e.g. "--duration"
+	Source string
+}
+
+func (e *DurationError) Error() string {
+	return fmt.Sprintf("%s of %s is outside the %s to %s STS allows for %s, use --clamp-duration to use %s instead",
+		e.Source, e.Duration, e.Min, e.Max, e.Kind, e.clamped())
+}
+
+func (e *DurationError) clamped() time.Duration {
+	if e.Duration < e.Min {
+		return e.Min
+	}
+	return e.Max
+}
+
+// durationLimits returns the longest duration STS allows and a description
+// of the credentials. Chained roles are roles assumed with temporary
+// credentials, including an MFA session.
+func durationLimits(assumesRole, chained bool) (time.Duration, string) {
+	switch {
+	case assumesRole && chained:
+		return maxChainedRoleDuration, "roles assumed with temporary credentials"
+	case assumesRole:
+		return maxRoleDuration, "roles"
+	default:
+		return maxSessionDuration, "session tokens"
+	}
+}
+
+// checkDuration returns an error if duration is out of range, or if clamp is
+// set it logs a warning and returns the closest duration that's allowed.
+func checkDuration(duration time.Duration, source string, assumesRole, chained, clamp bool) (time.Duration, error) {
+	max, kind := durationLimits(assumesRole, chained)
+	if duration >= minDuration && duration <= max {
+		return duration, nil
+	}
+
+	err := &DurationError{
+		Duration: duration,
+		Min:      minDuration,
+		Max:      max,
+		Kind:     kind,
+		Source:   source,
+	}
+	if !clamp {
+		return 0, err
+	}
+
+	log.WithField("prefix", "options").Warnf("%s of %s is outside the %s to %s STS allows for %s, using %s instead",
+		source, duration, minDuration, max, kind, err.clamped())
+
+	return err.clamped(), nil
+}
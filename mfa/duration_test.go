@@ -0,0 +1,116 @@
+package mfa
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckDuration(t *testing.T) {
+	tests := []struct {
+		name        string
+		duration    time.Duration
+		assumesRole bool
+		chained     bool
+		clamp       bool
+
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "session token", duration: 36 * time.Hour, want: 36 * time.Hour},
+		{name: "session token too long", duration: 48 * time.Hour, wantErr: true},
+		{name: "session token too short", duration: 5 * time.Minute, wantErr: true},
+		{name: "role", duration: 12 * time.Hour, assumesRole: true, want: 12 * time.Hour},
+		{name: "role too long", duration: 13 * time.Hour, assumesRole: true, wantErr: true},
+		{name: "chained role", duration: time.Hour, assumesRole: true, chained: true, want: time.Hour},
+		{name: "chained role too long", duration: 2 * time.Hour, assumesRole: true, chained: true, wantErr: true},
+		{name: "clamps a long duration", duration: 48 * time.Hour, clamp: true, want: 36 * time.Hour},
+		{name: "clamps a short duration", duration: time.Minute, assumesRole: true, clamp: true, want: 15 * time.Minute},
+		{name: "clamps a chained role", duration: 12 * time.Hour, assumesRole: true, chained: true, clamp: true, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		got, err := checkDuration(tt.duration, "--duration", tt.assumesRole, tt.chained, tt.clamp)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkDuration() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			if _, ok := err.(*DurationError); !ok {
+				t.Errorf("%s: checkDuration() error is a %T, want a *DurationError", tt.name, err)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: checkDuration() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDuration(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials string
+		options     Options
+
+		wantDuration time.Duration
+		wantErr      string
+	}{
+		{
+			name:         "defaults to 36h",
+			credentials:  permanentSection(""),
+			wantDuration: 36 * time.Hour,
+		},
+		{
+			name:        "rejects a long --duration",
+			credentials: permanentSection(""),
+			options:     Options{Duration: 48 * time.Hour},
+			wantErr:     "--duration of 48h0m0s is outside the 15m0s to 36h0m0s STS allows for session tokens",
+		},
+		{
+			name:        "rejects a long duration_seconds",
+			credentials: permanentSection("role_arn = arn:aws:iam::210987654321:role/admin\nduration_seconds = 86400"),
+			wantErr:     "duration_seconds in [default-permanent] of 24h0m0s is outside the 15m0s to 12h0m0s STS allows for roles",
+		},
+		{
+			name:         "clamps a long --duration",
+			credentials:  permanentSection(""),
+			options:      Options{Duration: 48 * time.Hour, ClampDuration: true},
+			wantDuration: 36 * time.Hour,
+		},
+		{
+			name:        "limits roles assumed with temporary credentials to an hour",
+			credentials: permanentSection("role_arn = arn:aws:iam::210987654321:role/admin\nsource_profile = session") + "\n[session]\naws_access_key_id = ASIASESSION\naws_secret_access_key = secret\naws_session_token = token\n",
+			options:     Options{Duration: 2 * time.Hour},
+			wantErr:     "STS allows for roles assumed with temporary credentials",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cleanup := writeCredentials(t, tt.credentials)
+			defer cleanup()
+
+			options := tt.options
+			options.CredentialsFileLocation = path
+			options.Profile = "default"
+			options.ProfileSuffix = "permanent"
+
+			config, err := options.Validate()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			defer config.Close()
+
+			if config.Options.Duration != tt.wantDuration {
+				t.Errorf("Duration = %s, want %s", config.Options.Duration, tt.wantDuration)
+			}
+		})
+	}
+}
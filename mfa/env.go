@@ -0,0 +1,151 @@
+package mfa
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profile variables are unset so the SDKs and CLI don't prefer a profile over
+// the credentials in the environment.
+var profileVariables = []string{"AWS_PROFILE", "AWS_DEFAULT_PROFILE"}
+
+// Variable is a single environment variable.
+type Variable struct {
+	Name  string
+	Value string
+}
+
+// Environment refreshes the temporary credentials if needed and returns them
+// as the environment variables understood by the AWS SDKs and CLI.
+func (r Refresher) Environment() ([]Variable, error) {
+	if err := r.Refresh(); err != nil {
+		return nil, err
+	}
+
+	credentials, expires, err := r.Credentials()
+	if err != nil {
+		r.log.WithError(err).Errorln("Failed to read the temporary credentials")
+		return nil, err
+	}
+
+	variables := []Variable{
+		{"AWS_ACCESS_KEY_ID", credentials.AccessKeyID},
+		{"AWS_SECRET_ACCESS_KEY", credentials.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", credentials.SessionToken},
+		{"AWS_SESSION_EXPIRATION", expires.UTC().Format(time.RFC3339)},
+	}
+
+	if region := r.Config.Settings.String(regionKey); region != "" {
+		variables = append(variables,
+			Variable{"AWS_REGION", region},
+			Variable{"AWS_DEFAULT_REGION", region},
+		)
+	}
+
+	return variables, nil
+}
+
+// Environ returns a copy of environ, in the form of os.Environ, with the
+// profile variables removed and the given variables set.
+func Environ(environ []string, variables []Variable) []string {
+	remove := map[string]bool{}
+	for _, name := range profileVariables {
+		remove[name] = true
+	}
+	for _, v := range variables {
+		remove[v.Name] = true
+	}
+
+	result := make([]string, 0, len(environ)+len(variables))
+	for _, kv := range environ {
+		if name := strings.SplitN(kv, "=", 2)[0]; !remove[name] {
+			result = append(result, kv)
+		}
+	}
+	for _, v := range variables {
+		result = append(result, v.Name+"="+v.Value)
+	}
+
+	return result
+}
+
+// Shell formats variables as commands for a particular shell.
+type Shell interface {
+	Export(name, value string) string
+	Unset(name string) string
+}
+
+type posixShell struct{}
+
+func (posixShell) Export(name, value string) string {
+	return fmt.Sprintf("export %s='%s'", name, strings.Replace(value, "'", `'\''`, -1))
+}
+
+func (posixShell) Unset(name string) string {
+	return fmt.Sprintf("unset %s", name)
+}
+
+type fishShell struct{}
+
+func (fishShell) Export(name, value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+	return fmt.Sprintf("set -gx %s '%s';", name, value)
+}
+
+func (fishShell) Unset(name string) string {
+	return fmt.Sprintf("set -e %s;", name)
+}
+
+type powerShell struct{}
+
+func (powerShell) Export(name, value string) string {
+	return fmt.Sprintf("$Env:%s = '%s'", name, strings.Replace(value, "'", "''", -1))
+}
+
+func (powerShell) Unset(name string) string {
+	return fmt.Sprintf("Remove-Item Env:%s -ErrorAction SilentlyContinue", name)
+}
+
+var shells = map[string]Shell{
+	"bash":       posixShell{},
+	"zsh":        posixShell{},
+	"sh":         posixShell{},
+	"fish":       fishShell{},
+	"powershell": powerShell{},
+	"pwsh":       powerShell{},
+}
+
+// Shells returns the names of the supported shells.
+func Shells() []string {
+	names := make([]string, 0, len(shells))
+	for name := range shells {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetShell returns the named shell.
+func GetShell(name string) (Shell, error) {
+	s, ok := shells[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported shell %q, must be one of %s", name, strings.Join(Shells(), ", "))
+	}
+	return s, nil
+}
+
+// Exports returns the commands that set variables in the shell.
+func Exports(s Shell, variables []Variable) string {
+	var lines []string
+	for _, name := range profileVariables {
+		lines = append(lines, s.Unset(name))
+	}
+	for _, v := range variables {
+		lines = append(lines, s.Export(v.Name, v.Value))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
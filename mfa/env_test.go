@@ -0,0 +1,101 @@
+package mfa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShellExport(t *testing.T) {
+	tests := []struct {
+		shell string
+		value string
+		want  string
+	}{
+		{"bash", "plain", `export TEST='plain'`},
+		{"bash", "it's", `export TEST='it'\''s'`},
+		{"bash", "$HOME and `id`", "export TEST='$HOME and `id`'"},
+		{"bash", "two words", `export TEST='two words'`},
+		{"bash", "two\nlines", "export TEST='two\nlines'"},
+		{"bash", `back\slash`, `export TEST='back\slash'`},
+
+		{"fish", "plain", `set -gx TEST 'plain';`},
+		{"fish", "it's", `set -gx TEST 'it\'s';`},
+		{"fish", "$HOME and (id)", `set -gx TEST '$HOME and (id)';`},
+		{"fish", "two words", `set -gx TEST 'two words';`},
+		{"fish", "two\nlines", "set -gx TEST 'two\nlines';"},
+		{"fish", `back\slash`, `set -gx TEST 'back\\slash';`},
+		{"fish", `trailing\`, `set -gx TEST 'trailing\\';`},
+
+		{"powershell", "plain", `$Env:TEST = 'plain'`},
+		{"powershell", "it's", `$Env:TEST = 'it''s'`},
+		{"powershell", "$HOME and $(id)", `$Env:TEST = '$HOME and $(id)'`},
+		{"powershell", "two words", `$Env:TEST = 'two words'`},
+		{"powershell", "two\nlines", "$Env:TEST = 'two\nlines'"},
+		{"powershell", "back`tick", "$Env:TEST = 'back`tick'"},
+	}
+
+	for _, tt := range tests {
+		s, err := GetShell(tt.shell)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Export("TEST", tt.value); got != tt.want {
+			t.Errorf("%s Export(%q) = %s, want %s", tt.shell, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExports(t *testing.T) {
+	s, err := GetShell("zsh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := Exports(s, []Variable{{"AWS_ACCESS_KEY_ID", "ASIAFAKE"}, {"AWS_SESSION_TOKEN", "to'ken"}})
+	want := strings.Join([]string{
+		"unset AWS_PROFILE",
+		"unset AWS_DEFAULT_PROFILE",
+		"export AWS_ACCESS_KEY_ID='ASIAFAKE'",
+		`export AWS_SESSION_TOKEN='to'\''ken'`,
+	}, "\n") + "\n"
+	if got != want {
+		t.Errorf("Exports() =\n%s\nwant\n%s", got, want)
+	}
+
+	if _, err := GetShell("cmd"); err == nil {
+		t.Error("GetShell(cmd) should fail")
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	environ := []string{
+		"HOME=/home/me",
+		"AWS_PROFILE=work",
+		"AWS_DEFAULT_PROFILE=work",
+		"AWS_PROFILE_EXTRA=kept",
+		"AWS_ACCESS_KEY_ID=AKIAOLD",
+		"EMPTY=",
+		"EQUALS=a=b",
+	}
+	variables := []Variable{
+		{"AWS_ACCESS_KEY_ID", "ASIAFAKE"},
+		{"AWS_SESSION_TOKEN", "token=with=equals"},
+	}
+
+	got := Environ(environ, variables)
+	want := []string{
+		"HOME=/home/me",
+		"AWS_PROFILE_EXTRA=kept",
+		"EMPTY=",
+		"EQUALS=a=b",
+		"AWS_ACCESS_KEY_ID=ASIAFAKE",
+		"AWS_SESSION_TOKEN=token=with=equals",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Environ() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if environ[1] != "AWS_PROFILE=work" {
+		t.Error("Environ() modified its input")
+	}
+}
@@ -0,0 +1,99 @@
+package mfa
+
+import (
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+// ErrorClass groups the errors STS returns by what can be done about them.
+type ErrorClass int
+
+const (
+	// UnknownError is any error that isn't one of the others
+	UnknownError ErrorClass = iota
+
+	// InvalidTokenError means the token code was wrong or already used
+	InvalidTokenError
+
+	// ThrottlingError means too many requests were made
+	ThrottlingError
+
+	// NetworkError means STS couldn't be reached
+	NetworkError
+
+	// InvalidCredentialsError means the permanent access key was rejected
+	InvalidCredentialsError
+)
+
+// invalidCredentialsCodes are returned when the access key used to sign the
+// request is expired, deactivated or deleted
+var invalidCredentialsCodes = map[string]bool{
+	"InvalidClientTokenId":  true,
+	"SignatureDoesNotMatch": true,
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+}
+
+// ClassifyError works out the class of an error returned by STS.
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return UnknownError
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch {
+		case aerr.Code() == "AccessDenied" && strings.Contains(aerr.Message(), "MultiFactorAuthentication"):
+			return InvalidTokenError
+		case invalidCredentialsCodes[aerr.Code()]:
+			return InvalidCredentialsError
+		case aws.IsErrorThrottle(err):
+			return ThrottlingError
+		case aerr.Code() == "RequestError":
+			return NetworkError
+		}
+
+		if _, ok := aerr.OrigErr().(net.Error); ok {
+			return NetworkError
+		}
+		return UnknownError
+	}
+
+	if _, ok := err.(net.Error); ok {
+		return NetworkError
+	}
+	return UnknownError
+}
+
+func (c ErrorClass) String() string {
+	switch c {
+	case InvalidTokenError:
+		return "invalid token code"
+	case ThrottlingError:
+		return "throttled"
+	case NetworkError:
+		return "network error"
+	case InvalidCredentialsError:
+		return "invalid permanent credentials"
+	default:
+		return "unknown error"
+	}
+}
+
+// hint suggests what to do about an error of the class
+func (c ErrorClass) hint() string {
+	switch c {
+	case InvalidTokenError:
+		return "Check the MFA device and wait for a new token code before trying again"
+	case ThrottlingError:
+		return "Wait a moment before trying again"
+	case NetworkError:
+		return "Check your network connection"
+	case InvalidCredentialsError:
+		return "Your permanent access key was rejected, it may have been deactivated or rotated"
+	default:
+		return ""
+	}
+}
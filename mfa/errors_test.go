@@ -0,0 +1,35 @@
+package mfa
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"invalid token code", invalidTokenErr, InvalidTokenError},
+		{"other access denied", awserr.New("AccessDenied", "User is not authorized to perform: sts:AssumeRole", nil), UnknownError},
+		{"deactivated key", awserr.New("InvalidClientTokenId", "The security token included in the request is invalid.", nil), InvalidCredentialsError},
+		{"wrong secret", awserr.New("SignatureDoesNotMatch", "The request signature we calculated does not match", nil), InvalidCredentialsError},
+		{"expired token", awserr.New("ExpiredToken", "The security token included in the request is expired", nil), InvalidCredentialsError},
+		{"throttling", awserr.New("Throttling", "Rate exceeded", nil), ThrottlingError},
+		{"request error", awserr.New("RequestError", "send request failed", errors.New("connection refused")), NetworkError},
+		{"wrapped network error", awserr.New("SerializationError", "failed", &net.OpError{Op: "dial", Err: errors.New("no route to host")}), NetworkError},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("no route to host")}, NetworkError},
+		{"other error", errors.New("something else"), UnknownError},
+		{"nil", nil, UnknownError},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("%s: ClassifyError() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
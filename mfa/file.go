@@ -0,0 +1,205 @@
+package mfa
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultLockTimeout is how long to wait for another aws-mfa to release the
+// credentials file, unless it's refreshing.
+const DefaultLockTimeout = 10 * time.Second
+
+// writeFileAtomic replaces the file at path with what write produces. The new
+// contents go to a temporary file in the same directory which is synced and
+// renamed over the original, so readers never see a partially written file.
+// The mode and ownership of an existing file are preserved, and a symlink is
+// followed so the file it points to is replaced rather than the link.
+func writeFileAtomic(path string, write func(w io.Writer) error) (err error) {
+	path, err = resolveSymlinks(path)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0600)
+	info, statErr := os.Stat(path)
+	if statErr == nil {
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(statErr) {
+		return statErr
+	}
+
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if statErr == nil {
+		if err = chown(tmp, info); err != nil {
+			return err
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// resolveSymlinks returns the file path points to, or path itself if it
+// doesn't exist yet
+func resolveSymlinks(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return path, nil
+	}
+	return resolved, err
+}
+
+// FileLock is an advisory lock held on a file next to the one being guarded.
+// While the holder is refreshing it also holds a second lock, so others know
+// to wait for it rather than give up.
+type FileLock struct {
+	file       *os.File
+	refreshing *os.File
+	name       string
+}
+
+// LockFile takes an exclusive lock on path + ".lock", waiting up to timeout
+// for another process to release it, or for as long as it takes when that
+// process is refreshing. The lock is next to the file a symlink points to, so
+// every link to a file shares its lock.
+func LockFile(path string, timeout time.Duration) (*FileLock, error) {
+	path, err := resolveSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	name := path + ".lock"
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	waiting := false
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if locked {
+			return &FileLock{file: file, name: name}, nil
+		}
+		if time.Now().After(deadline) {
+			if !lockRefreshing(name) {
+				file.Close()
+				return nil, fmt.Errorf("timed out after %s waiting for the lock on %s", timeout, name)
+			}
+			if !waiting {
+				log.WithField("prefix", "lock").Infoln("Waiting for another aws-mfa to finish refreshing")
+				waiting = true
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// refreshingLockName is the lock held while the holder of name is refreshing
+func refreshingLockName(name string) string {
+	return name + ".refreshing"
+}
+
+// lockRefreshing returns true if the holder of the lock name is refreshing
+func lockRefreshing(name string) bool {
+	file, err := os.OpenFile(refreshingLockName(name), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	locked, err := tryLock(file)
+	if err != nil {
+		return false
+	}
+	if locked {
+		unlock(file)
+	}
+	return !locked
+}
+
+// Refreshing tells processes waiting for the lock that a refresh is in
+// progress, which may be waiting on a token code, so they wait for it to
+// finish instead of timing out. It lasts until the lock is released.
+func (l *FileLock) Refreshing() error {
+	if l == nil || l.file == nil || l.refreshing != nil {
+		return nil
+	}
+
+	file, err := os.OpenFile(refreshingLockName(l.name), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if locked {
+			break
+		}
+		// a waiting process is checking on it, which only takes a moment
+		time.Sleep(10 * time.Millisecond)
+	}
+	l.refreshing = file
+	return nil
+}
+
+// Unlock releases the lock, it's safe to call more than once.
+func (l *FileLock) Unlock() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	if l.refreshing != nil {
+		unlock(l.refreshing)
+		l.refreshing.Close()
+		l.refreshing = nil
+	}
+
+	err := unlock(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+
+	return err
+}
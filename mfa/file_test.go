@@ -0,0 +1,181 @@
+package mfa
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	path, cleanup := writeCredentials(t, "old contents")
+	defer cleanup()
+
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "new contents")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("writeFileAtomic() error = %v", err)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "new contents" {
+		t.Errorf("contents = %q, want %q", contents, "new contents")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0640))
+	}
+}
+
+func TestWriteFileAtomicFailureKeepsOriginal(t *testing.T) {
+	path, cleanup := writeCredentials(t, "old contents")
+	defer cleanup()
+
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return io.ErrUnexpectedEOF
+	})
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("writeFileAtomic() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	contents, _ := ioutil.ReadFile(path)
+	if string(contents) != "old contents" {
+		t.Errorf("contents = %q, want the original", contents)
+	}
+
+	dir, _ := os.Open(strings.TrimSuffix(path, "credentials"))
+	names, _ := dir.Readdirnames(-1)
+	dir.Close()
+	for _, name := range names {
+		if strings.Contains(name, ".tmp") {
+			t.Errorf("temporary file %s was left behind", name)
+		}
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	path, cleanup := writeCredentials(t, "")
+	defer cleanup()
+
+	lock, err := LockFile(path, time.Second)
+	if err != nil {
+		t.Fatalf("LockFile() error = %v", err)
+	}
+
+	if _, err := LockFile(path, 200*time.Millisecond); err == nil {
+		t.Fatal("LockFile() succeeded while the lock was held")
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("second Unlock() error = %v", err)
+	}
+
+	again, err := LockFile(path, time.Second)
+	if err != nil {
+		t.Fatalf("LockFile() after Unlock() error = %v", err)
+	}
+	again.Unlock()
+}
+
+func TestLockFileRefreshing(t *testing.T) {
+	path, cleanup := writeCredentials(t, "")
+	defer cleanup()
+
+	lock, err := LockFile(path, time.Second)
+	if err != nil {
+		t.Fatalf("LockFile() error = %v", err)
+	}
+	if err := lock.Refreshing(); err != nil {
+		t.Fatalf("Refreshing() error = %v", err)
+	}
+
+	// a refresh waiting on a token code outlasts the timeout, but others
+	// wait for it instead of giving up
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		close(released)
+		lock.Unlock()
+	}()
+
+	again, err := LockFile(path, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("LockFile() error = %v, want it to wait for the refresh", err)
+	}
+	select {
+	case <-released:
+	default:
+		t.Error("LockFile() returned while the lock was held")
+	}
+
+	// once the refresh is over, the timeout applies again
+	if _, err := LockFile(path, 100*time.Millisecond); err == nil {
+		t.Error("LockFile() succeeded while the lock was held")
+	}
+	again.Unlock()
+}
+
+func TestWriteFileAtomicSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs extra privileges on windows")
+	}
+
+	path, cleanup := writeCredentials(t, "old contents")
+	defer cleanup()
+
+	link := filepath.Join(filepath.Dir(path), "linked")
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := LockFile(link, time.Second)
+	if err != nil {
+		t.Fatalf("LockFile() error = %v", err)
+	}
+	defer lock.Unlock()
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Errorf("the lock isn't next to the file the link points to: %v", err)
+	}
+
+	err = writeFileAtomic(link, func(w io.Writer) error {
+		_, err := io.WriteString(w, "new contents")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("writeFileAtomic() error = %v", err)
+	}
+
+	if info, err := os.Lstat(link); err != nil {
+		t.Fatal(err)
+	} else if info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("the symlink was replaced with a %v file", info.Mode())
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "new contents" {
+		t.Errorf("contents of the target = %q, want %q", contents, "new contents")
+	}
+}
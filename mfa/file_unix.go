@@ -0,0 +1,57 @@
+//go:build !windows
+// +build !windows
+
+package mfa
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+func chown(file *os.File, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if err := file.Chown(int(stat.Uid), int(stat.Gid)); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
+
+// syncDir makes sure a rename within dir is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// not every filesystem supports syncing directories
+	if err := d.Sync(); err != nil && err != syscall.EINVAL {
+		return err
+	}
+	return nil
+}
+
+func tryLock(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
+
+// listenUnix creates the socket at path with the umask set so only the current
+// user can connect, there's no window where it's open to others
+func listenUnix(path string) (net.Listener, error) {
+	umask := syscall.Umask(0077)
+	defer syscall.Umask(umask)
+
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,72 @@
+//go:build windows
+// +build windows
+
+package mfa
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+
+	errorLockViolation syscall.Errno = 33
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// ownership is inherited from the directory on windows
+func chown(file *os.File, info os.FileInfo) error {
+	return nil
+}
+
+func syncDir(dir string) error {
+	return nil
+}
+
+func tryLock(file *os.File) (bool, error) {
+	var overlapped syscall.Overlapped
+	r, _, err := procLockFileEx.Call(
+		file.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately,
+		0,
+		1,
+		0,
+		uintptr(unsafe.Pointer(&overlapped)),
+	)
+	if r == 0 {
+		if err == errorLockViolation {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func unlock(file *os.File) error {
+	var overlapped syscall.Overlapped
+	r, _, err := procUnlockFileEx.Call(
+		file.Fd(),
+		0,
+		1,
+		0,
+		uintptr(unsafe.Pointer(&overlapped)),
+	)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// the socket gets the permissions of the directory on windows
+func listenUnix(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,55 @@
+package mfa
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/endpoints"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// IAM is the subset of the IAM API used to rotate access keys and find MFA
+// devices. The requests are made as the user the credentials belong to.
+type IAM interface {
+	ListAccessKeys(input *iam.ListAccessKeysInput) (*iam.ListAccessKeysOutput, error)
+	CreateAccessKey(input *iam.CreateAccessKeyInput) (*iam.CreateAccessKeyOutput, error)
+	UpdateAccessKey(input *iam.UpdateAccessKeyInput) (*iam.UpdateAccessKeyOutput, error)
+	DeleteAccessKey(input *iam.DeleteAccessKeyInput) (*iam.DeleteAccessKeyOutput, error)
+	ListMFADevices(input *iam.ListMFADevicesInput) (*iam.ListMFADevicesOutput, error)
+}
+
+// IAMFactory creates the IAM client used to rotate access keys and find MFA
+// devices.
+type IAMFactory func(cfg aws.Config) IAM
+
+// NewIAM is the IAMFactory that talks to AWS.
+func NewIAM(cfg aws.Config) IAM {
+	// IAM is global, requests are signed for us-east-1 wherever they're made
+	if cfg.Region == "" {
+		cfg.Region = endpoints.UsEast1RegionID
+	}
+
+	return iamClient{svc: iam.New(cfg)}
+}
+
+type iamClient struct {
+	svc *iam.IAM
+}
+
+func (c iamClient) ListAccessKeys(input *iam.ListAccessKeysInput) (*iam.ListAccessKeysOutput, error) {
+	return c.svc.ListAccessKeysRequest(input).Send()
+}
+
+func (c iamClient) CreateAccessKey(input *iam.CreateAccessKeyInput) (*iam.CreateAccessKeyOutput, error) {
+	return c.svc.CreateAccessKeyRequest(input).Send()
+}
+
+func (c iamClient) UpdateAccessKey(input *iam.UpdateAccessKeyInput) (*iam.UpdateAccessKeyOutput, error) {
+	return c.svc.UpdateAccessKeyRequest(input).Send()
+}
+
+func (c iamClient) DeleteAccessKey(input *iam.DeleteAccessKeyInput) (*iam.DeleteAccessKeyOutput, error) {
+	return c.svc.DeleteAccessKeyRequest(input).Send()
+}
+
+func (c iamClient) ListMFADevices(input *iam.ListMFADevicesInput) (*iam.ListMFADevicesOutput, error) {
+	return c.svc.ListMFADevicesRequest(input).Send()
+}
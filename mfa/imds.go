@@ -0,0 +1,205 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	imdsTokenPath       = "/latest/api/token"
+	imdsCredentialsPath = "/latest/meta-data/iam/security-credentials/"
+
+	imdsTokenHeader    = "X-aws-ec2-metadata-token"
+	imdsTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+
+	// maxIMDSTokenTTL is the longest an IMDSv2 token can last, 6 hours
+	maxIMDSTokenTTL = 21600
+
+	// imdsHost is the address the real service is reached at
+	imdsHost = "169.254.169.254"
+)
+
+// CredentialsSource provides the credentials to serve, Daemon is one.
+type CredentialsSource interface {
+	Credentials() (*sts.Credentials, error)
+}
+
+// IMDSCredentials is the document the instance metadata service returns for
+// a role.
+type IMDSCredentials struct {
+	Code            string
+	LastUpdated     string
+	Type            string
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+}
+
+// IMDSHandler emulates the credentials endpoints of the EC2 instance metadata
+// service, including the IMDSv2 session token flow. Only requests for the
+// address it's listening on or the address of the real service are answered,
+// so a web page can't read the credentials with DNS rebinding.
+type IMDSHandler struct {
+	log    *logrus.Entry
+	source CredentialsSource
+
+	// address is the host and port the handler is served on
+	address string
+
+	// Role is the name credentials are served under
+	Role string
+
+	// AllowIMDSv1 answers requests that don't send a session token
+	AllowIMDSv1 bool
+
+	now func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+// NewIMDSHandler creates a handler serving credentials from source as role,
+// on the listener at address.
+func NewIMDSHandler(source CredentialsSource, role, address string) *IMDSHandler {
+	return &IMDSHandler{
+		log:     log.WithFields(logrus.Fields{"prefix": "imds", "role": role}),
+		source:  source,
+		address: address,
+		Role:    role,
+		now:     time.Now,
+		tokens:  map[string]time.Time{},
+	}
+}
+
+func (h *IMDSHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !h.validHost(req.Host) {
+		h.log.WithField("host", req.Host).Warnln("Rejected a request for another host")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if req.URL.Path == imdsTokenPath {
+		h.serveToken(w, req)
+		return
+	}
+
+	if req.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.authorized(req) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	switch req.URL.Path {
+	case imdsCredentialsPath, strings.TrimSuffix(imdsCredentialsPath, "/"):
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(h.Role))
+	case imdsCredentialsPath + h.Role:
+		h.serveCredentials(w)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// serveToken issues an IMDSv2 session token
+func (h *IMDSHandler) serveToken(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// like the real service, refuse requests that went through a proxy
+	if req.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	ttl, err := strconv.Atoi(req.Header.Get(imdsTokenTTLHeader))
+	if err != nil || ttl < 1 || ttl > maxIMDSTokenTTL {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(b)
+
+	h.mu.Lock()
+	now := h.now()
+	for t, expires := range h.tokens {
+		if !now.Before(expires) {
+			delete(h.tokens, t)
+		}
+	}
+	h.tokens[token] = now.Add(time.Duration(ttl) * time.Second)
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set(imdsTokenTTLHeader, strconv.Itoa(ttl))
+	w.Write([]byte(token))
+}
+
+// validHost returns true if host is the address the handler is served on, or
+// the address of the real service
+func (h *IMDSHandler) validHost(host string) bool {
+	if host == h.address {
+		return true
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return host == imdsHost
+}
+
+// authorized returns true if req has an unexpired session token, or doesn't
+// have one and IMDSv1 is allowed
+func (h *IMDSHandler) authorized(req *http.Request) bool {
+	token := req.Header.Get(imdsTokenHeader)
+	if token == "" {
+		return h.AllowIMDSv1
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	expires, ok := h.tokens[token]
+	return ok && h.now().Before(expires)
+}
+
+func (h *IMDSHandler) serveCredentials(w http.ResponseWriter) {
+	credentials, err := h.source.Credentials()
+	if err != nil {
+		h.log.WithError(err).Errorln("Failed to get credentials")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(IMDSCredentials{
+		Code:            "Success",
+		LastUpdated:     h.now().UTC().Format(time.RFC3339),
+		Type:            "AWS-HMAC",
+		AccessKeyID:     aws.StringValue(credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(credentials.SecretAccessKey),
+		Token:           aws.StringValue(credentials.SessionToken),
+		Expiration:      aws.TimeValue(credentials.Expiration).UTC().Format(time.RFC3339),
+	})
+}
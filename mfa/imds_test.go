@@ -0,0 +1,208 @@
+package mfa
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// staticSource always returns the same credentials
+type staticSource struct {
+	credentials *sts.Credentials
+	err         error
+}
+
+func (s staticSource) Credentials() (*sts.Credentials, error) {
+	return s.credentials, s.err
+}
+
+func newTestIMDS(source CredentialsSource, allowV1 bool) (*IMDSHandler, *httptest.Server) {
+	server := httptest.NewUnstartedServer(nil)
+	handler := NewIMDSHandler(source, "admin", server.Listener.Addr().String())
+	handler.AllowIMDSv1 = allowV1
+	server.Config.Handler = handler
+	server.Start()
+	return handler, server
+}
+
+func imdsRequest(t *testing.T, method, url string, headers map[string]string) (int, string) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		if name == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestIMDSCredentials(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+	source := staticSource{credentials: &sts.Credentials{
+		AccessKeyId:     aws.String("ASIAFAKEACCESSKEYID"),
+		SecretAccessKey: aws.String("fake-secret-access-key"),
+		SessionToken:    aws.String("fake-session-token"),
+		Expiration:      aws.Time(expiration),
+	}}
+
+	_, server := newTestIMDS(source, true)
+	defer server.Close()
+
+	status, body := imdsRequest(t, http.MethodGet, server.URL+imdsCredentialsPath, nil)
+	if status != http.StatusOK || body != "admin" {
+		t.Errorf("GET %s = %d %q, want 200 \"admin\"", imdsCredentialsPath, status, body)
+	}
+
+	if status, _ := imdsRequest(t, http.MethodGet, server.URL+imdsCredentialsPath+"other", nil); status != http.StatusNotFound {
+		t.Errorf("GET %sother = %d, want 404", imdsCredentialsPath, status)
+	}
+
+	status, body = imdsRequest(t, http.MethodGet, server.URL+imdsCredentialsPath+"admin", nil)
+	if status != http.StatusOK {
+		t.Fatalf("GET %sadmin = %d %q, want 200", imdsCredentialsPath, status, body)
+	}
+
+	var credentials IMDSCredentials
+	if err := json.Unmarshal([]byte(body), &credentials); err != nil {
+		t.Fatal(err)
+	}
+	want := IMDSCredentials{
+		Code:            "Success",
+		LastUpdated:     credentials.LastUpdated,
+		Type:            "AWS-HMAC",
+		AccessKeyID:     "ASIAFAKEACCESSKEYID",
+		SecretAccessKey: "fake-secret-access-key",
+		Token:           "fake-session-token",
+		Expiration:      expiration.UTC().Format(time.RFC3339),
+	}
+	if credentials != want {
+		t.Errorf("credentials = %+v, want %+v", credentials, want)
+	}
+}
+
+func TestIMDSCredentialsError(t *testing.T) {
+	_, server := newTestIMDS(staticSource{err: errors.New("AccessDenied")}, true)
+	defer server.Close()
+
+	if status, _ := imdsRequest(t, http.MethodGet, server.URL+imdsCredentialsPath+"admin", nil); status != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", status, http.StatusInternalServerError)
+	}
+}
+
+func TestIMDSToken(t *testing.T) {
+	source := staticSource{credentials: &sts.Credentials{Expiration: aws.Time(time.Now().Add(time.Hour))}}
+
+	handler, server := newTestIMDS(source, false)
+	defer server.Close()
+
+	now := time.Now()
+	handler.now = func() time.Time { return now }
+
+	tokenTests := []struct {
+		name       string
+		method     string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"requires a PUT", http.MethodGet, map[string]string{imdsTokenTTLHeader: "60"}, http.StatusMethodNotAllowed},
+		{"requires a ttl", http.MethodPut, nil, http.StatusBadRequest},
+		{"rejects a ttl that's too long", http.MethodPut, map[string]string{imdsTokenTTLHeader: "21601"}, http.StatusBadRequest},
+		{"rejects proxied requests", http.MethodPut, map[string]string{imdsTokenTTLHeader: "60", "X-Forwarded-For": "10.0.0.1"}, http.StatusForbidden},
+		{"issues a token", http.MethodPut, map[string]string{imdsTokenTTLHeader: "60"}, http.StatusOK},
+	}
+
+	var token string
+	for _, tt := range tokenTests {
+		status, body := imdsRequest(t, tt.method, server.URL+imdsTokenPath, tt.headers)
+		if status != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, status, tt.wantStatus)
+		}
+		if status == http.StatusOK {
+			token = body
+		}
+	}
+	if token == "" {
+		t.Fatal("no token was issued")
+	}
+
+	url := server.URL + imdsCredentialsPath + "admin"
+
+	if status, _ := imdsRequest(t, http.MethodGet, url, nil); status != http.StatusUnauthorized {
+		t.Errorf("IMDSv1 request status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status, _ := imdsRequest(t, http.MethodGet, url, map[string]string{imdsTokenHeader: "invalid"}); status != http.StatusUnauthorized {
+		t.Errorf("invalid token status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status, _ := imdsRequest(t, http.MethodGet, url, map[string]string{imdsTokenHeader: token}); status != http.StatusOK {
+		t.Errorf("valid token status = %d, want %d", status, http.StatusOK)
+	}
+
+	now = now.Add(time.Minute)
+	if status, _ := imdsRequest(t, http.MethodGet, url, map[string]string{imdsTokenHeader: token}); status != http.StatusUnauthorized {
+		t.Errorf("expired token status = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestIMDSHost(t *testing.T) {
+	_, server := newTestIMDS(staticSource{}, true)
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	hosts := []struct {
+		host       string
+		wantStatus int
+	}{
+		{server.Listener.Addr().String(), http.StatusOK},
+		{"169.254.169.254", http.StatusOK},
+		{"169.254.169.254:80", http.StatusOK},
+		{"attacker.example", http.StatusForbidden},
+		{"attacker.example:" + port, http.StatusForbidden},
+		{"localhost", http.StatusForbidden},
+	}
+
+	for _, tt := range hosts {
+		headers := map[string]string{"Host": tt.host}
+		if status, _ := imdsRequest(t, http.MethodGet, server.URL+imdsCredentialsPath, headers); status != tt.wantStatus {
+			t.Errorf("Host %s: status = %d, want %d", tt.host, status, tt.wantStatus)
+		}
+	}
+
+	headers := map[string]string{"Host": "attacker.example", imdsTokenTTLHeader: "60"}
+	if status, _ := imdsRequest(t, http.MethodPut, server.URL+imdsTokenPath, headers); status != http.StatusForbidden {
+		t.Errorf("token for another host: status = %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+func TestIMDSv1Disabled(t *testing.T) {
+	_, server := newTestIMDS(staticSource{}, false)
+	defer server.Close()
+
+	for _, path := range []string{imdsCredentialsPath, imdsCredentialsPath + "admin"} {
+		if status, _ := imdsRequest(t, http.MethodGet, server.URL+path, nil); status != http.StatusUnauthorized {
+			t.Errorf("IMDSv1 GET %s: status = %d, want %d", path, status, http.StatusUnauthorized)
+		}
+	}
+}
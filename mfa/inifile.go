@@ -0,0 +1,349 @@
+package mfa
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// iniSection is where a section and its keys are found in the lines of a file
+type iniSection struct {
+	name    string
+	header  int              // -1 for keys before the first section
+	lastKey int              // last line of a key, or the header
+	keys    map[string][]int // the first line of each key
+}
+
+// iniLines splits data into lines and finds its sections
+type iniLines struct {
+	lines     []string
+	lineBreak string
+	// trailingBreak is false when the last line didn't end with a line break
+	trailingBreak bool
+	sections      map[string]*iniSection
+	order         []string
+	// valueEnds is the last line of values continued on the following
+	// lines, by the line of their key
+	valueEnds map[int]int
+}
+
+func parseINILines(data []byte) *iniLines {
+	text := string(data)
+
+	doc := &iniLines{
+		lineBreak: "\n",
+		sections:  map[string]*iniSection{},
+		valueEnds: map[int]int{},
+	}
+	if strings.Contains(text, "\r\n") {
+		doc.lineBreak = "\r\n"
+	}
+
+	doc.trailingBreak = text == "" || strings.HasSuffix(text, "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text != "" {
+		doc.lines = strings.Split(text, "\n")
+	}
+
+	current := &iniSection{name: ini.DEFAULT_SECTION, header: -1, lastKey: -1, keys: map[string][]int{}}
+	doc.sections[current.name] = current
+	doc.order = append(doc.order, current.name)
+
+	for i := 0; i < len(doc.lines); i++ {
+		trimmed := strings.TrimSpace(doc.lines[i])
+		switch {
+		case trimmed == "", trimmed[0] == '#', trimmed[0] == ';':
+			continue
+		case trimmed[0] == '[' && strings.Contains(trimmed, "]"):
+			name := strings.TrimSpace(trimmed[1:strings.LastIndex(trimmed, "]")])
+			if s, ok := doc.sections[name]; ok {
+				// a repeated section continues the previous one
+				current = s
+				continue
+			}
+			current = &iniSection{name: name, header: i, lastKey: i, keys: map[string][]int{}}
+			doc.sections[name] = current
+			doc.order = append(doc.order, name)
+		default:
+			name, value := trimmed, ""
+			if end := strings.IndexAny(trimmed, "=:"); end >= 0 {
+				name, value = strings.TrimSpace(trimmed[:end]), strings.TrimSpace(trimmed[end+1:])
+			}
+			current.keys[name] = append(current.keys[name], i)
+			if last := doc.lastValueLine(i, value); last > i {
+				doc.valueEnds[i] = last
+				i = last
+			}
+			current.lastKey = i
+		}
+	}
+
+	return doc
+}
+
+// lastValueLine returns the last line of the value on line i, which is past i
+// when go-ini continues it: a value opened with """ or a backtick lasts until
+// the line closing it, and one ending with a backslash lasts until a line that
+// doesn't or a blank line
+func (d *iniLines) lastValueLine(i int, value string) int {
+	quote := ""
+	if len(value) > 3 && strings.HasPrefix(value, `"""`) {
+		quote = `"""`
+	} else if strings.HasPrefix(value, "`") {
+		quote = "`"
+	}
+
+	if quote != "" {
+		if strings.Contains(value[len(quote):], quote) {
+			return i
+		}
+		for j := i + 1; j < len(d.lines); j++ {
+			if strings.Contains(d.lines[j], quote) {
+				return j
+			}
+		}
+		return len(d.lines) - 1
+	}
+
+	for j := i; j < len(d.lines); j++ {
+		next := strings.TrimSpace(d.lines[j])
+		if next == "" {
+			return j - 1
+		}
+		if !strings.HasSuffix(next, `\`) {
+			return j
+		}
+	}
+	return len(d.lines) - 1
+}
+
+// valueLines returns the lines of the key on line i and its value
+func (d *iniLines) valueLines(i int) []int {
+	last, ok := d.valueEnds[i]
+	if !ok {
+		last = i
+	}
+
+	lines := make([]int, 0, last-i+1)
+	for ; i <= last; i++ {
+		lines = append(lines, i)
+	}
+	return lines
+}
+
+// formatINIValue quotes a value the same way go-ini does
+func formatINIValue(value string) string {
+	if strings.ContainsAny(value, "\n`") {
+		return `"""` + value + `"""`
+	} else if strings.ContainsAny(value, "#;") {
+		return "`" + value + "`"
+	}
+	return value
+}
+
+// replaceINIValue swaps the value on a key line, keeping everything up to it
+func replaceINIValue(line, value string) string {
+	i := strings.IndexAny(line, "=:")
+	if i < 0 {
+		return line
+	}
+	i++
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	return line[:i] + formatINIValue(value)
+}
+
+// keyLine formats a new key so that it lines up with the existing keys in the
+// section when they're aligned, and otherwise uses the same separator as the
+// last key in the section
+func (d *iniLines) keyLine(section *iniSection, name, value string) string {
+	var lines []int
+	for _, i := range section.keys {
+		lines = append(lines, i...)
+	}
+	sort.Ints(lines)
+
+	column, separator, spaced, aligned := -1, "", false, true
+	for _, i := range lines {
+		line := strings.TrimSuffix(d.lines[i], "\r")
+		equals := strings.IndexAny(line, "=:")
+		if equals < 0 {
+			continue
+		}
+		if column != -1 && column != equals {
+			aligned = false
+		}
+		after := line[equals+1:]
+		column = equals
+		separator = line[equals : len(line)-len(strings.TrimLeft(after, " \t"))]
+		spaced = equals > 0 && (line[equals-1] == ' ' || line[equals-1] == '\t')
+	}
+
+	switch {
+	case column < 0:
+		// nothing to line up with, use the go-ini format
+		return name + " = " + formatINIValue(value)
+	case aligned && column >= len(name):
+		return name + strings.Repeat(" ", column-len(name)) + separator + formatINIValue(value)
+	case spaced:
+		return name + " " + separator + formatINIValue(value)
+	default:
+		return name + separator + formatINIValue(value)
+	}
+}
+
+// newSectionLines formats a section that wasn't in the file, like go-ini would
+func newSectionLines(section *ini.Section) []string {
+	align := 0
+	for _, name := range section.KeyStrings() {
+		if len(name) > align {
+			align = len(name)
+		}
+	}
+
+	lines := []string{"[" + section.Name() + "]"}
+	for _, key := range section.Keys() {
+		lines = append(lines, key.Name()+strings.Repeat(" ", align-len(key.Name()))+" = "+formatINIValue(key.Value()))
+	}
+	return lines
+}
+
+// patchINI applies the differences between the contents of original and file
+// to the lines of original. Comments, blank lines, ordering and formatting of
+// anything that didn't change are left exactly as they were.
+func patchINI(original []byte, file *ini.File) ([]byte, error) {
+	before, err := ini.Load(original)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := parseINILines(original)
+
+	replace := map[int]string{}
+	remove := map[int]bool{}
+	insert := map[int][]string{}
+	var appended [][]string
+
+	for _, section := range file.Sections() {
+		existing, ok := doc.sections[section.Name()]
+		if !ok {
+			if len(section.Keys()) > 0 || section.Name() != ini.DEFAULT_SECTION {
+				appended = append(appended, newSectionLines(section))
+			}
+			continue
+		}
+
+		old, _ := before.GetSection(section.Name())
+		for _, key := range section.Keys() {
+			lines := existing.keys[key.Name()]
+			if len(lines) == 0 {
+				insert[existing.lastKey] = append(insert[existing.lastKey], doc.keyLine(existing, key.Name(), key.Value()))
+				continue
+			}
+			if old == nil || !old.HasKey(key.Name()) || old.Key(key.Name()).Value() != key.Value() {
+				last := lines[len(lines)-1]
+				replace[last] = replaceINIValue(doc.lines[last], key.Value())
+				// the rest of a multi-line value goes with it
+				for _, i := range doc.valueLines(last)[1:] {
+					remove[i] = true
+				}
+			}
+		}
+
+		for name, lines := range existing.keys {
+			if !section.HasKey(name) {
+				for _, i := range lines {
+					for _, j := range doc.valueLines(i) {
+						remove[j] = true
+					}
+				}
+			}
+		}
+	}
+
+	for _, name := range doc.order {
+		if _, err := file.GetSection(name); err == nil || name == ini.DEFAULT_SECTION {
+			continue
+		}
+		existing := doc.sections[name]
+		for i := existing.header; i <= existing.lastKey; i++ {
+			remove[i] = true
+		}
+		for _, lines := range existing.keys {
+			for _, i := range lines {
+				for _, j := range doc.valueLines(i) {
+					remove[j] = true
+				}
+			}
+		}
+		// take the blank line separating it from the next section too, or
+		// from the previous one when it's the last
+		if next := existing.lastKey + 1; next < len(doc.lines) && strings.TrimSpace(doc.lines[next]) == "" {
+			remove[next] = true
+		} else if previous := existing.header - 1; next >= len(doc.lines) && previous >= 0 && strings.TrimSpace(doc.lines[previous]) == "" {
+			remove[previous] = true
+		}
+	}
+
+	var out []string
+	out = append(out, insert[-1]...)
+	for i, line := range doc.lines {
+		if v, ok := replace[i]; ok {
+			line = v
+		}
+		if !remove[i] {
+			out = append(out, line)
+		}
+		out = append(out, insert[i]...)
+	}
+
+	for _, lines := range appended {
+		if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) != "" {
+			out = append(out, "")
+		}
+		out = append(out, lines...)
+	}
+
+	var buf bytes.Buffer
+	for i, line := range out {
+		buf.WriteString(strings.TrimSuffix(line, "\r"))
+		if i < len(out)-1 || doc.trailingBreak || len(appended) > 0 {
+			buf.WriteString(doc.lineBreak)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// renameINISection renames every header of a section in data, everything else
+// is left exactly as it was
+func renameINISection(data []byte, from, to string) []byte {
+	doc := parseINILines(data)
+
+	continued := map[int]bool{}
+	for i := range doc.valueEnds {
+		for _, j := range doc.valueLines(i)[1:] {
+			continued[j] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, line := range doc.lines {
+		trimmed := strings.TrimSpace(line)
+		if !continued[i] && trimmed != "" && trimmed[0] == '[' && strings.Contains(trimmed, "]") &&
+			strings.TrimSpace(trimmed[1:strings.LastIndex(trimmed, "]")]) == from {
+			start, end := strings.Index(line, "["), strings.LastIndex(line, "]")
+			line = line[:start+1] + to + line[end:]
+		}
+
+		buf.WriteString(line)
+		if i < len(doc.lines)-1 || doc.trailingBreak {
+			buf.WriteString("\n")
+		}
+	}
+
+	return buf.Bytes()
+}
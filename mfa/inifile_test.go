@@ -0,0 +1,211 @@
+package mfa
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestPatchINIUnchanged(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range inputs {
+		original, err := ioutil.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		variants := map[string][]byte{
+			"lf":                original,
+			"crlf":              bytes.Replace(original, []byte("\n"), []byte("\r\n"), -1),
+			"no trailing break": bytes.TrimSuffix(original, []byte("\n")),
+		}
+		for name, data := range variants {
+			file, err := ini.Load(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := patchINI(data, file)
+			if err != nil {
+				t.Fatalf("%s %s: patchINI() error = %v", input, name, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("%s %s: patchINI() changed an unmodified file:\n%s", input, name, got)
+			}
+		}
+	}
+}
+
+func TestPatchINIMultiline(t *testing.T) {
+	const original = "[s]\nk = \"\"\"line1\nline2\"\"\"\nc = a \\\n  b\nother = 1\n"
+
+	tests := []struct {
+		name   string
+		change func(s *ini.Section)
+		want   string
+	}{
+		{
+			name:   "changing another key",
+			change: func(s *ini.Section) { s.Key("other").SetValue("2") },
+			want:   "[s]\nk = \"\"\"line1\nline2\"\"\"\nc = a \\\n  b\nother = 2\n",
+		},
+		{
+			name:   "adding a key",
+			change: func(s *ini.Section) { s.Key("new").SetValue("3") },
+			want:   "[s]\nk = \"\"\"line1\nline2\"\"\"\nc = a \\\n  b\nother = 1\nnew = 3\n",
+		},
+		{
+			name:   "changing the multi-line values",
+			change: func(s *ini.Section) { s.Key("k").SetValue("single"); s.Key("c").SetValue("d") },
+			want:   "[s]\nk = single\nc = d\nother = 1\n",
+		},
+		{
+			name:   "removing the multi-line values",
+			change: func(s *ini.Section) { s.DeleteKey("k"); s.DeleteKey("c") },
+			want:   "[s]\nother = 1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		file, err := ini.Load([]byte(original))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := file.Section("s").Key("k").String(); got != "line1\nline2" {
+			t.Fatalf("k = %q, want the value on both lines", got)
+		}
+		tt.change(file.Section("s"))
+
+		got, err := patchINI([]byte(original), file)
+		if err != nil {
+			t.Fatalf("%s: patchINI() error = %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: patchINI() =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+
+		// what was written reads back the same
+		patched, err := ini.Load(got)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, key := range file.Section("s").Keys() {
+			if value := patched.Section("s").Key(key.Name()).String(); value != key.String() {
+				t.Errorf("%s: %s = %q after patching, want %q", tt.name, key.Name(), value, key.String())
+			}
+		}
+	}
+}
+
+func TestSaveGolden(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		options Options
+		clear   bool
+	}{
+		{name: "refresh", input: "refresh.input"},
+		{name: "clear", input: "refresh.input", clear: true},
+		{name: "new_section", input: "new_section.input", options: Options{MFASerial: testSerial}},
+		{name: "mixed_separators", input: "mixed_separators.input", options: Options{MFASerial: testSerial}},
+		{name: "multiline", input: "multiline.input"},
+	}
+
+	// expires is written in local time
+	local := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = local }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, err := ioutil.ReadFile(filepath.Join("testdata", tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path, cleanup := writeCredentials(t, string(input))
+			defer cleanup()
+
+			options := tt.options
+			options.CredentialsFileLocation = path
+			options.Profile = "default"
+			options.ProfileSuffix = "permanent"
+
+			config, err := options.Validate()
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			defer config.Close()
+
+			fake := &fakeSTS{expiration: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)}
+			refresher, err := NewRefresher(config, fake.factory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			refresher.tokens = StaticToken("123456")
+			refresher.now = func() time.Time { return time.Date(2030, 1, 1, 15, 4, 5, 0, time.UTC) }
+
+			if tt.clear {
+				err = refresher.Clear(false)
+			} else {
+				err = refresher.Refresh()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("credentials file doesn't match %s, got:\n%s", golden, got)
+			}
+
+			// sections other than the temporary and permanent ones must be
+			// byte for byte identical
+			for _, section := range unrelatedSections(string(input)) {
+				if !strings.Contains(string(got), section) {
+					t.Errorf("unrelated section was modified:\n%s", section)
+				}
+			}
+		})
+	}
+}
+
+// unrelatedSections returns the text of the sections in a file that aren't
+// the default temporary or permanent sections
+func unrelatedSections(data string) []string {
+	var sections []string
+	for _, chunk := range strings.Split(data, "\n[")[1:] {
+		if strings.HasPrefix(chunk, "default]") || strings.HasPrefix(chunk, "default-permanent]") {
+			continue
+		}
+		sections = append(sections, "["+chunk)
+	}
+	return sections
+}
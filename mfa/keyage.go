@@ -0,0 +1,108 @@
+package mfa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultKeyAgeWarning is how old the permanent access key can get before
+// refreshing warns about it.
+const DefaultKeyAgeWarning = 90 * 24 * time.Hour
+
+const (
+	// When the permanent access key was created and last rotated by aws-mfa
+	createdKey = `created`
+	rotatedKey = `rotated`
+
+	keyAgeWarningKey = `key_age_warning`
+	maxKeyAgeKey     = `max_key_age`
+)
+
+// ParseAge parses a number of days such as 90d, or a duration such as 2160h.
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid age %q, expected a number of days like 90d or a duration like 2160h", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	age, err := time.ParseDuration(s)
+	if err != nil || age <= 0 {
+		return 0, fmt.Errorf("invalid age %q, expected a number of days like 90d or a duration like 2160h", s)
+	}
+	return age, nil
+}
+
+// formatAge formats an age in days
+func formatAge(age time.Duration) string {
+	return fmt.Sprintf("%dd", int(age.Hours()/24))
+}
+
+// KeyAgeError is returned when the permanent access key is older than the
+// maximum age allowed.
+type KeyAgeError struct {
+	Profile string
+	Age     time.Duration
+	Max     time.Duration
+}
+
+func (e *KeyAgeError) Error() string {
+	return fmt.Sprintf("the access key in [%s] is %s old, older than the %s allowed by --max-key-age, rotate it with 'aws-mfa rotate'",
+		e.Profile, formatAge(e.Age), formatAge(e.Max))
+}
+
+// keyCreated returns when the access key in the source section was created.
+// When it isn't known, the current time is recorded so the age is counted
+// from the first time aws-mfa sees the key. Nothing is recorded when the
+// credentials file is read-only, the age is counted from now every time.
+func (r Refresher) keyCreated() time.Time {
+	section := r.Config.Source.Section
+
+	for _, name := range []string{createdKey, rotatedKey} {
+		if !section.HasKey(name) {
+			continue
+		}
+		created, err := section.Key(name).TimeFormat(time.RFC3339)
+		if err == nil {
+			return created
+		}
+		r.log.WithError(err).WithField("profile", r.Config.Source.Profile).Warnf("Ignoring %s, it isn't an RFC3339 time", name)
+	}
+
+	now := r.now().UTC()
+	r.log.WithField("profile", r.Config.Source.Profile).Infof("Don't know when the access key was created, counting its age from now. Set %s if it's older", createdKey)
+	if !r.Config.permanentReadOnly() {
+		section.Key(createdKey).SetValue(now.Format(time.RFC3339))
+	}
+	return now
+}
+
+// checkKeyAge warns when the permanent access key is older than the warning
+// threshold, and returns a KeyAgeError when it's older than the maximum age.
+// Temporary source credentials aren't checked.
+func (r Refresher) checkKeyAge() error {
+	if r.Config.Source.Section.HasKey(sessionTokenKey) {
+		return nil
+	}
+
+	options := r.Config.Options
+	age := r.now().Sub(r.keyCreated())
+	logger := r.log.WithField("profile", r.Config.Source.Profile)
+
+	switch {
+	case options.MaxKeyAge != 0 && age > options.MaxKeyAge:
+		err := &KeyAgeError{Profile: r.Config.Source.Profile, Age: age, Max: options.MaxKeyAge}
+		logger.WithError(err).Errorln("The access key is too old")
+		return err
+	case age > options.KeyAgeWarning:
+		logger.Warnf("The access key is %s old, consider rotating it with 'aws-mfa rotate'", formatAge(age))
+	}
+
+	return nil
+}
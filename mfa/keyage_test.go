@@ -0,0 +1,106 @@
+package mfa
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "90d", want: 90 * 24 * time.Hour},
+		{in: " 30d ", want: 30 * 24 * time.Hour},
+		{in: "2160h", want: 2160 * time.Hour},
+		{in: "0d", wantErr: true},
+		{in: "-1d", wantErr: true},
+		{in: "1.5d", wantErr: true},
+		{in: "0s", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseAge(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshKeyAge(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) string {
+		return now.Add(-time.Duration(days) * 24 * time.Hour).Format(time.RFC3339)
+	}
+
+	tests := []struct {
+		name        string
+		extra       string
+		maxKeyAge   time.Duration
+		wantErr     bool
+		wantCreated string
+	}{
+		{name: "unknown age is recorded", wantCreated: now.Format(time.RFC3339)},
+		{name: "new key", extra: "created = " + daysAgo(10), maxKeyAge: 30 * 24 * time.Hour, wantCreated: daysAgo(10)},
+		{name: "old key only warns", extra: "created = " + daysAgo(100), wantCreated: daysAgo(100)},
+		{name: "too old", extra: "created = " + daysAgo(100), maxKeyAge: 90 * 24 * time.Hour, wantErr: true, wantCreated: daysAgo(100)},
+		{name: "too old from the setting", extra: "created = " + daysAgo(100) + "\nmax_key_age = 90d", wantErr: true, wantCreated: daysAgo(100)},
+		{name: "rotated since", extra: "rotated = " + daysAgo(10), maxKeyAge: 90 * 24 * time.Hour},
+		{name: "temporary source isn't checked", extra: "aws_session_token = token\ncreated = " + daysAgo(100), maxKeyAge: 90 * 24 * time.Hour, wantCreated: daysAgo(100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cleanup := writeCredentials(t, permanentSection("mfa_serial = "+testSerial+"\n"+tt.extra))
+			defer cleanup()
+
+			config, err := Options{
+				CredentialsFileLocation: path,
+				Profile:                 "default",
+				ProfileSuffix:           "permanent",
+				MaxKeyAge:               tt.maxKeyAge,
+			}.Validate()
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			defer config.Close()
+
+			fake := &fakeSTS{now: func() time.Time { return now }}
+			refresher, _ := NewRefresher(config, fake.factory)
+			refresher.tokens = StaticToken("123456")
+			refresher.now = func() time.Time { return now }
+
+			err = refresher.Refresh()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Refresh() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, ok := err.(*KeyAgeError); !ok {
+					t.Errorf("Refresh() error = %T, want a KeyAgeError", err)
+				}
+				// fail before asking for a token code
+				if fake.calls() != 0 {
+					t.Errorf("STS calls = %d, want 0", fake.calls())
+				}
+				return
+			}
+
+			saved, err := ini.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := saved.Section("default-permanent").Key(createdKey).String(); got != tt.wantCreated {
+				t.Errorf("%s = %q, want %q", createdKey, got, tt.wantCreated)
+			}
+		})
+	}
+}
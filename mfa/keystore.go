@@ -0,0 +1,428 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Backends the access keys of a permanent profile can be kept in
+const (
+	// INIKeyStore keeps them in the permanent section, in plaintext
+	INIKeyStore = "ini"
+
+	// EncryptedKeyStore keeps them in a file encrypted with a passphrase
+	EncryptedKeyStore = "encrypted"
+
+	// ExecKeyStore keeps them with a plugin, see execKeyStore
+	ExecKeyStore = "exec"
+)
+
+// KeyStores lists the key store backends.
+func KeyStores() []string {
+	return []string{INIKeyStore, EncryptedKeyStore, ExecKeyStore}
+}
+
+// keyStoreKey picks the backend of a permanent section
+const keyStoreKey = `key_store`
+
+// PassphraseEnvVar is the environment variable the passphrase of the
+// encrypted key store is read from.
+const PassphraseEnvVar = "AWS_MFA_PASSPHRASE"
+
+// passphraseCost is the scrypt CPU/memory cost new key stores use, it's
+// stored in the file with the other parameters so it can be raised later
+var passphraseCost = 1 << 15
+
+// The scrypt block size and parallelization of new key stores
+const (
+	passphraseBlockSize       = 8
+	passphraseParallelization = 1
+)
+
+// DefaultKeyStoreFile returns the location of the encrypted key store, next
+// to the credentials file.
+func DefaultKeyStoreFile(credentialsFile string) string {
+	return filepath.Join(filepath.Dir(credentialsFile), "aws-mfa-keys")
+}
+
+// KeyStore keeps the access keys of permanent profiles.
+type KeyStore interface {
+	AccessKey(profile string) (id, secret string, err error)
+	SetAccessKey(profile, id, secret string) error
+	DeleteAccessKey(profile string) error
+}
+
+// iniKeyStore keeps access keys in the credentials file, they're written when
+// the config is saved
+type iniKeyStore struct {
+	file *ini.File
+}
+
+func (s iniKeyStore) AccessKey(profile string) (string, string, error) {
+	section, err := s.file.GetSection(profile)
+	if err != nil || !section.HasKey(accessKeyIDKey) || !section.HasKey(secretAccessKey) {
+		return "", "", fmt.Errorf("no static credentials found in the [%s] section", profile)
+	}
+	return section.Key(accessKeyIDKey).String(), section.Key(secretAccessKey).String(), nil
+}
+
+func (s iniKeyStore) SetAccessKey(profile, id, secret string) error {
+	section := s.file.Section(profile)
+	section.Key(accessKeyIDKey).SetValue(id)
+	section.Key(secretAccessKey).SetValue(secret)
+	return nil
+}
+
+func (s iniKeyStore) DeleteAccessKey(profile string) error {
+	if section, err := s.file.GetSection(profile); err == nil {
+		section.DeleteKey(accessKeyIDKey)
+		section.DeleteKey(secretAccessKey)
+	}
+	return nil
+}
+
+// encryptedKeyStore keeps access keys in a file encrypted with NaCl secretbox,
+// using a key derived from a passphrase with scrypt. The file is read the
+// first time a key is needed and written whenever one is set.
+type encryptedKeyStore struct {
+	path string
+
+	// passphrase returns the passphrase, confirm is set when the file
+	// doesn't exist yet
+	passphrase func(confirm bool) (string, error)
+
+	loaded bool
+	key    *[32]byte
+	kdf    scryptParams
+	keys   map[string]storedAccessKey
+}
+
+type storedAccessKey struct {
+	AccessKeyID     string `json:"aws_access_key_id"`
+	SecretAccessKey string `json:"aws_secret_access_key"`
+}
+
+// scryptParams are the parameters the key is derived from the passphrase with
+type scryptParams struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+}
+
+// deriveKey derives the secretbox key from a passphrase
+func (p scryptParams) deriveKey(passphrase string) (*[32]byte, error) {
+	derived, err := scrypt.Key([]byte(passphrase), p.Salt, p.N, p.R, p.P, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	key := new([32]byte)
+	copy(key[:], derived)
+	return key, nil
+}
+
+// encryptedFile is what's written to disk, the nonce is prepended to the
+// ciphertext
+type encryptedFile struct {
+	Version    int          `json:"version"`
+	KDF        string       `json:"kdf"`
+	Params     scryptParams `json:"params"`
+	Ciphertext []byte       `json:"ciphertext"`
+}
+
+func newEncryptedKeyStore(path string) *encryptedKeyStore {
+	return &encryptedKeyStore{path: path, passphrase: readPassphrase}
+}
+
+// readPassphrase reads the passphrase from the environment or the terminal
+func readPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv(PassphraseEnvVar); passphrase != "" {
+		return passphrase, nil
+	}
+
+	logger := log.WithField("prefix", "keys")
+	logger.Printf("Enter the passphrase of the key store")
+	passphrase, err := readSecret()
+	if err != nil || !confirm {
+		return passphrase, err
+	}
+
+	logger.Printf("Enter the passphrase again")
+	again, err := readSecret()
+	if err != nil {
+		return "", err
+	}
+	if again != passphrase {
+		return "", fmt.Errorf("the passphrases don't match")
+	}
+	return passphrase, nil
+}
+
+func (s *encryptedKeyStore) load() error {
+	if s.loaded {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s.create()
+	}
+	if err != nil {
+		return err
+	}
+
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to read the key store %s: %v", s.path, err)
+	}
+	if file.Version != 1 || file.KDF != "scrypt" {
+		return fmt.Errorf("the key store %s has an unsupported format, version %d using %s", s.path, file.Version, file.KDF)
+	}
+
+	passphrase, err := s.passphrase(false)
+	if err != nil {
+		return err
+	}
+	key, err := file.Params.deriveKey(passphrase)
+	if err != nil {
+		return fmt.Errorf("the key store %s is corrupted: %v", s.path, err)
+	}
+
+	if len(file.Ciphertext) < 24 {
+		return fmt.Errorf("the key store %s is corrupted", s.path)
+	}
+	var nonce [24]byte
+	copy(nonce[:], file.Ciphertext)
+	plaintext, ok := secretbox.Open(nil, file.Ciphertext[len(nonce):], &nonce, key)
+	if !ok {
+		return fmt.Errorf("failed to decrypt the key store %s, the passphrase is wrong or the file is corrupted", s.path)
+	}
+
+	keys := map[string]storedAccessKey{}
+	if err := json.Unmarshal(plaintext, &keys); err != nil {
+		return fmt.Errorf("failed to read the key store %s: %v", s.path, err)
+	}
+
+	s.loaded, s.key, s.kdf, s.keys = true, key, file.Params, keys
+	return nil
+}
+
+// create sets up an empty store with a new passphrase, it's written when the
+// first key is set
+func (s *encryptedKeyStore) create() error {
+	log.WithField("prefix", "keys").Infof("Creating the key store %s", s.path)
+
+	passphrase, err := s.passphrase(true)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+
+	kdf := scryptParams{N: passphraseCost, R: passphraseBlockSize, P: passphraseParallelization, Salt: salt}
+	key, err := kdf.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	s.loaded, s.key, s.kdf, s.keys = true, key, kdf, map[string]storedAccessKey{}
+	return nil
+}
+
+func (s *encryptedKeyStore) AccessKey(profile string) (string, string, error) {
+	if err := s.load(); err != nil {
+		return "", "", err
+	}
+
+	key, ok := s.keys[profile]
+	if !ok {
+		return "", "", fmt.Errorf("no access key for [%s] found in the key store %s", profile, s.path)
+	}
+	return key.AccessKeyID, key.SecretAccessKey, nil
+}
+
+func (s *encryptedKeyStore) SetAccessKey(profile, id, secret string) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	return s.update(func(keys map[string]storedAccessKey) {
+		keys[profile] = storedAccessKey{AccessKeyID: id, SecretAccessKey: secret}
+	})
+}
+
+func (s *encryptedKeyStore) DeleteAccessKey(profile string) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	return s.update(func(keys map[string]storedAccessKey) {
+		delete(keys, profile)
+	})
+}
+
+// update writes a copy of the keys changed by change, and keeps it once it's
+// been written
+func (s *encryptedKeyStore) update(change func(keys map[string]storedAccessKey)) error {
+	keys := make(map[string]storedAccessKey, len(s.keys)+1)
+	for name, key := range s.keys {
+		keys[name] = key
+	}
+	change(keys)
+
+	if err := s.write(keys); err != nil {
+		return err
+	}
+	s.keys = keys
+	return nil
+}
+
+// write encrypts keys with a new nonce and atomically replaces the file
+func (s *encryptedKeyStore) write(keys map[string]storedAccessKey) error {
+	plaintext, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(encryptedFile{
+		Version:    1,
+		KDF:        "scrypt",
+		Params:     s.kdf,
+		Ciphertext: secretbox.Seal(nonce[:], plaintext, &nonce, s.key),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
+
+// keyStore returns the store the access keys of a section are kept in
+func (c *Config) keyStore(v ConfigValue) (KeyStore, error) {
+	var backend, command string
+	if v.Section.HasKey(keyStoreKey) {
+		backend = v.Section.Key(keyStoreKey).String()
+	}
+	if v.Section.HasKey(keyStoreCommandKey) {
+		command = v.Section.Key(keyStoreCommandKey).String()
+	}
+
+	store, err := c.newKeyStore(backend, command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key store for [%s]: %v", v.Profile, err)
+	}
+	return store, nil
+}
+
+// newKeyStore returns the store for a backend, command is the plugin used by
+// the exec backend
+func (c *Config) newKeyStore(backend, command string) (KeyStore, error) {
+	switch backend {
+	case "", INIKeyStore:
+		return iniKeyStore{file: c.CredentialsFile}, nil
+	case EncryptedKeyStore:
+		return c.store.encrypted, nil
+	case ExecKeyStore:
+		if strings.TrimSpace(command) == "" {
+			return nil, fmt.Errorf("%s needs a %s", ExecKeyStore, keyStoreCommandKey)
+		}
+		return execKeyStore{command: command}, nil
+	default:
+		return nil, fmt.Errorf("unknown %s %q, expected one of: %s", keyStoreKey, backend, strings.Join(KeyStores(), ", "))
+	}
+}
+
+// AccessKey returns the access key used to call STS, from the store of the
+// source section.
+func (c *Config) AccessKey() (id, secret string, err error) {
+	store, err := c.keyStore(c.Source)
+	if err != nil {
+		return "", "", err
+	}
+	return store.AccessKey(c.Source.Profile)
+}
+
+// SetAccessKey replaces the access key of the source section. Keys kept in
+// the credentials file are written when the config is saved, encrypted ones
+// straight away.
+func (c *Config) SetAccessKey(id, secret string) error {
+	store, err := c.keyStore(c.Source)
+	if err != nil {
+		return err
+	}
+	return store.SetAccessKey(c.Source.Profile, id, secret)
+}
+
+// ImportAccessKey moves the access key of the permanent section into
+// another key store and saves the credentials file without it. Command is
+// the plugin used by the exec backend.
+func (c *Config) ImportAccessKey(backend, command string) error {
+	permanent := c.Permanent
+	if permanent.Section.HasKey(keyStoreKey) && permanent.Section.Key(keyStoreKey).String() != INIKeyStore {
+		return fmt.Errorf("the access key of [%s] is already kept in the %s key store", permanent.Profile, permanent.Section.Key(keyStoreKey).String())
+	}
+	if permanent.Section.HasKey(sessionTokenKey) {
+		return fmt.Errorf("the [%s] section has temporary credentials, only access keys can be imported", permanent.Profile)
+	}
+	if backend == INIKeyStore {
+		return fmt.Errorf("the access key of [%s] is already in the credentials file", permanent.Profile)
+	}
+
+	store, err := c.newKeyStore(backend, command)
+	if err != nil {
+		return err
+	}
+
+	id, secret, err := iniKeyStore{file: c.CredentialsFile}.AccessKey(permanent.Profile)
+	if err != nil {
+		return err
+	}
+
+	// write the key store first, so the key isn't lost if saving the
+	// credentials file fails
+	if err := store.SetAccessKey(permanent.Profile, id, secret); err != nil {
+		return err
+	}
+
+	permanent.Section.DeleteKey(accessKeyIDKey)
+	permanent.Section.DeleteKey(secretAccessKey)
+	permanent.Section.Key(keyStoreKey).SetValue(backend)
+	if backend == ExecKeyStore {
+		permanent.Section.Key(keyStoreCommandKey).SetValue(command)
+	}
+
+	logger := log.WithFields(logrus.Fields{"prefix": "keys", "profile": permanent.Profile})
+	if err := c.Save(); err != nil {
+		// the key is still in the credentials file, don't keep a second copy
+		if err := store.DeleteAccessKey(permanent.Profile); err != nil {
+			logger.WithError(err).Errorln("Failed to remove the access key from the key store again")
+		}
+		return err
+	}
+
+	logger.Infof("Moved the access key %s to the %s key store", id, backend)
+	return nil
+}
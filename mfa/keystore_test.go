@@ -0,0 +1,149 @@
+package mfa
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-ini/ini"
+)
+
+// lowCost makes deriving keys fast in tests and returns a function that
+// restores the default
+func lowCost() func() {
+	cost := passphraseCost
+	passphraseCost = 1 << 10
+	return func() { passphraseCost = cost }
+}
+
+func TestEncryptedKeyStore(t *testing.T) {
+	defer lowCost()()
+
+	dir, err := ioutil.TempDir("", "aws-mfa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "aws-mfa-keys")
+
+	var confirmed []bool
+	passphrase := func(secret string) func(bool) (string, error) {
+		return func(confirm bool) (string, error) {
+			confirmed = append(confirmed, confirm)
+			return secret, nil
+		}
+	}
+
+	store := &encryptedKeyStore{path: path, passphrase: passphrase("correct horse")}
+	if _, _, err := store.AccessKey("work-permanent"); err == nil {
+		t.Fatal("AccessKey() of a missing profile should fail")
+	}
+	if err := store.SetAccessKey("work-permanent", "AKIAWORK", "work-secret"); err != nil {
+		t.Fatalf("SetAccessKey() error = %v", err)
+	}
+	if len(confirmed) != 1 || !confirmed[0] {
+		t.Errorf("passphrase confirmations = %v, want a single confirmed one for a new store", confirmed)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "work-secret") || strings.Contains(string(data), "AKIAWORK") {
+		t.Errorf("the key store contains the access key in plaintext:\n%s", data)
+	}
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if file.KDF != "scrypt" || file.Params.N != passphraseCost || len(file.Params.Salt) != 16 {
+		t.Errorf("the key store has kdf %s with %+v, want scrypt with N = %d and a 16 byte salt", file.KDF, file.Params, passphraseCost)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	reopened := &encryptedKeyStore{path: path, passphrase: passphrase("correct horse")}
+	id, secret, err := reopened.AccessKey("work-permanent")
+	if err != nil {
+		t.Fatalf("AccessKey() error = %v", err)
+	}
+	if id != "AKIAWORK" || secret != "work-secret" {
+		t.Errorf("AccessKey() = %s, %s, want AKIAWORK, work-secret", id, secret)
+	}
+	if confirmed[len(confirmed)-1] {
+		t.Error("the passphrase of an existing store shouldn't be confirmed")
+	}
+
+	wrong := &encryptedKeyStore{path: path, passphrase: passphrase("battery staple")}
+	if _, _, err := wrong.AccessKey("work-permanent"); err == nil {
+		t.Error("AccessKey() with the wrong passphrase should fail")
+	}
+}
+
+func TestImportAccessKey(t *testing.T) {
+	defer lowCost()()
+	os.Setenv(PassphraseEnvVar, "correct horse")
+	defer os.Unsetenv(PassphraseEnvVar)
+
+	path, cleanup := writeCredentials(t, permanentSection("mfa_serial = "+testSerial))
+	defer cleanup()
+
+	options := Options{
+		CredentialsFileLocation: path,
+		Profile:                 "default",
+		ProfileSuffix:           "permanent",
+		Token:                   "123456",
+	}
+	config, err := options.Validate()
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	if err := config.ImportAccessKey(EncryptedKeyStore, ""); err != nil {
+		t.Fatalf("ImportAccessKey() error = %v", err)
+	}
+	if err := config.ImportAccessKey(EncryptedKeyStore, ""); err == nil {
+		t.Error("importing twice should fail")
+	}
+	config.Close()
+
+	saved, err := ini.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	section := saved.Section("default-permanent")
+	for _, key := range []string{accessKeyIDKey, secretAccessKey} {
+		if section.HasKey(key) {
+			t.Errorf("%s is still in the credentials file", key)
+		}
+	}
+	if got := section.Key(keyStoreKey).String(); got != EncryptedKeyStore {
+		t.Errorf("%s = %q, want %q", keyStoreKey, got, EncryptedKeyStore)
+	}
+	if _, err := os.Stat(DefaultKeyStoreFile(path)); err != nil {
+		t.Errorf("key store wasn't written: %v", err)
+	}
+
+	// refreshing reads the key from the key store
+	config, err = options.Validate()
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	defer config.Close()
+
+	fake := &fakeSTS{}
+	refresher, _ := NewRefresher(config, fake.factory)
+	if err := refresher.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if len(fake.accessKeys) == 0 || fake.accessKeys[0] != "AKIAPERMANENT" {
+		t.Errorf("STS clients were created with %v, want AKIAPERMANENT first", fake.accessKeys)
+	}
+}
@@ -0,0 +1,158 @@
+package mfa
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/go-ini/ini"
+	"github.com/sirupsen/logrus"
+)
+
+// backupTimeFormat is used in the names of the backups of the credentials
+// file made before migrating
+const backupTimeFormat = "20060102T150405Z"
+
+// MigrateProfile moves a plain profile holding a long-lived access key into
+// the permanent/temporary layout, by renaming its section to the permanent
+// one. Everything in the section is kept as it was. A timestamped backup of
+// the credentials file is written first, its path is returned.
+func (o Options) MigrateProfile() (string, error) {
+	backup, err := o.migrate("migrate", func(file *ini.File, data []byte) ([]byte, error) {
+		permanentProfile := o.permanentProfile()
+
+		section, err := file.GetSection(o.Profile)
+		if err != nil {
+			return nil, fmt.Errorf("there's no [%s] section in %s to migrate", o.Profile, o.CredentialsFileLocation)
+		}
+		if _, err := file.GetSection(permanentProfile); err == nil {
+			return nil, fmt.Errorf("[%s] already exists, %s has already been migrated", permanentProfile, o.Profile)
+		}
+		if section.HasKey(sessionTokenKey) {
+			return nil, fmt.Errorf("[%s] holds temporary credentials, only a profile with a long-lived access key can be migrated", o.Profile)
+		}
+		if !section.HasKey(accessKeyIDKey) {
+			return nil, fmt.Errorf("[%s] doesn't have an access key to migrate", o.Profile)
+		}
+
+		return renameINISection(data, o.Profile, permanentProfile), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.WithFields(logrus.Fields{"prefix": "migrate", "profile": o.Profile}).
+		Infof("Renamed [%s] to [%s], run 'aws-mfa --profile %s' to get temporary credentials", o.Profile, o.permanentProfile(), o.Profile)
+	return backup, nil
+}
+
+// UndoMigrateProfile reverses MigrateProfile. The temporary section, which
+// can only hold credentials written by aws-mfa, is removed and the permanent
+// section is renamed back to the profile. The credentials file is backed up
+// first like when migrating.
+func (o Options) UndoMigrateProfile() (string, error) {
+	backup, err := o.migrate("undo", func(file *ini.File, data []byte) ([]byte, error) {
+		permanentProfile := o.permanentProfile()
+
+		if _, err := file.GetSection(permanentProfile); err != nil {
+			return nil, fmt.Errorf("there's no [%s] section in %s, %s hasn't been migrated", permanentProfile, o.CredentialsFileLocation, o.Profile)
+		}
+
+		if section, err := file.GetSection(o.Profile); err == nil {
+			for _, name := range section.KeyStrings() {
+				if !isTemporaryKey(name) {
+					return nil, fmt.Errorf("[%s] has %s, which aws-mfa doesn't write, move it to [%s] before undoing", o.Profile, name, permanentProfile)
+				}
+			}
+
+			file.DeleteSection(o.Profile)
+			if data, err = patchINI(data, file); err != nil {
+				return nil, err
+			}
+		}
+
+		return renameINISection(data, permanentProfile, o.Profile), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.WithFields(logrus.Fields{"prefix": "migrate", "profile": o.Profile}).
+		Infof("Renamed [%s] back to [%s]", o.permanentProfile(), o.Profile)
+	return backup, nil
+}
+
+// isTemporaryKey returns true for the keys aws-mfa writes to the temporary
+// section
+func isTemporaryKey(name string) bool {
+	for _, key := range temporaryKeys {
+		if name == key {
+			return true
+		}
+	}
+	return false
+}
+
+// migrate changes the credentials file with change, after writing a backup
+// named after the action
+func (o Options) migrate(action string, change func(file *ini.File, data []byte) ([]byte, error)) (string, error) {
+	logger := log.WithFields(logrus.Fields{"prefix": "migrate", "profile": o.Profile})
+
+	if o.Verbose {
+		log.SetLevel(logrus.DebugLevel)
+	}
+	o = o.withDefaults()
+
+	if o.permanentProfile() == o.Profile {
+		return "", &SameProfileError{Profile: o.Profile}
+	}
+
+	lock, err := o.lockCredentials()
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
+
+	path := o.CredentialsFileLocation
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", &MissingFileError{Path: path, Err: err}
+	}
+	file, err := ini.Load(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the credentials file %s: %v", path, err)
+	}
+
+	changed, err := change(file, data)
+	if err != nil {
+		logger.WithError(err).Errorln("Can't change the credentials file")
+		return "", err
+	}
+
+	backup := fmt.Sprintf("%s.%s-%s.bak", path, action, time.Now().UTC().Format(backupTimeFormat))
+	if _, err := os.Stat(backup); err == nil {
+		return "", fmt.Errorf("the backup %s already exists, try again in a second", backup)
+	}
+	err = writeFileAtomic(backup, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to back up the credentials file")
+		return "", err
+	}
+	logger.WithField("backup", backup).Infoln("Backed up the credentials file")
+
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(changed)
+		return err
+	})
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to save the credentials file")
+		return "", err
+	}
+
+	return backup, nil
+}
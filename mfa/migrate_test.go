@@ -0,0 +1,203 @@
+package mfa
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// plainProfile is a profile holding a long-lived access key, before it's
+// migrated
+const plainProfile = `# work account
+[work]
+aws_access_key_id     = AKIAWORK
+aws_secret_access_key = work-secret
+region                = eu-west-1 ; keep this
+
+[other]
+aws_access_key_id = AKIAOTHER
+`
+
+func TestMigrateProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials string
+		suffix      string
+		wantErr     bool
+		want        string
+	}{
+		{
+			name:        "migrate",
+			credentials: plainProfile,
+			suffix:      "permanent",
+			want:        strings.Replace(plainProfile, "[work]", "[work-permanent]", 1),
+		},
+		{
+			name:        "suffix",
+			credentials: plainProfile,
+			suffix:      "long-term",
+			want:        strings.Replace(plainProfile, "[work]", "[work-long-term]", 1),
+		},
+		{
+			name:        "CRLF and spacing",
+			credentials: "[work]  \r\naws_access_key_id = AKIAWORK\r\naws_secret_access_key = work-secret\r\n",
+			suffix:      "permanent",
+			want:        "[work-permanent]  \r\naws_access_key_id = AKIAWORK\r\naws_secret_access_key = work-secret\r\n",
+		},
+		{
+			name:        "missing profile",
+			credentials: "[other]\naws_access_key_id = AKIAOTHER\n",
+			suffix:      "permanent",
+			wantErr:     true,
+		},
+		{
+			name:        "already migrated",
+			credentials: plainProfile + "\n[work-permanent]\naws_access_key_id = AKIAWORK\n",
+			suffix:      "permanent",
+			wantErr:     true,
+		},
+		{
+			name:        "temporary credentials",
+			credentials: "[work]\naws_access_key_id = ASIAWORK\naws_secret_access_key = work-secret\naws_session_token = token\n",
+			suffix:      "permanent",
+			wantErr:     true,
+		},
+		{
+			name:        "no access key",
+			credentials: "[work]\nrole_arn = arn:aws:iam::123456789012:role/admin\n",
+			suffix:      "permanent",
+			wantErr:     true,
+		},
+		{
+			name:        "no suffix",
+			credentials: plainProfile,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cleanup := writeCredentials(t, tt.credentials)
+			defer cleanup()
+
+			backup, err := Options{
+				CredentialsFileLocation: path,
+				Profile:                 "work",
+				ProfileSuffix:           tt.suffix,
+			}.MigrateProfile()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MigrateProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr {
+				if string(data) != tt.credentials {
+					t.Errorf("MigrateProfile() changed the credentials file:\n%s", data)
+				}
+				if backups, _ := filepath.Glob(path + ".*.bak"); len(backups) != 0 {
+					t.Errorf("MigrateProfile() wrote backups %v", backups)
+				}
+				return
+			}
+
+			if string(data) != tt.want {
+				t.Errorf("MigrateProfile() wrote:\n%q\nwant:\n%q", data, tt.want)
+			}
+			if saved, err := ioutil.ReadFile(backup); err != nil || string(saved) != tt.credentials {
+				t.Errorf("the backup %s doesn't have the original contents: %v\n%s", backup, err, saved)
+			}
+			if filepath.Dir(backup) != filepath.Dir(path) || !strings.HasPrefix(filepath.Base(backup), "credentials.migrate-") {
+				t.Errorf("backup = %s, want it next to the credentials file", backup)
+			}
+
+			config, err := Options{
+				CredentialsFileLocation: path,
+				Profile:                 "work",
+				ProfileSuffix:           tt.suffix,
+			}.Validate()
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			defer config.Close()
+			if id, _, _ := config.AccessKey(); id != "AKIAWORK" {
+				t.Errorf("AccessKey() = %s, want AKIAWORK", id)
+			}
+		})
+	}
+}
+
+func TestUndoMigrateProfile(t *testing.T) {
+	migrated := strings.Replace(plainProfile, "[work]", "[work-permanent]", 1)
+
+	tests := []struct {
+		name        string
+		credentials string
+		wantErr     bool
+		want        string
+	}{
+		{
+			name:        "undo",
+			credentials: migrated,
+			want:        plainProfile,
+		},
+		{
+			name: "after refreshing",
+			credentials: migrated + `
+[work]
+aws_access_key_id     = ASIAWORK
+aws_secret_access_key = temporary-secret
+aws_session_token     = token
+expires               = 2030-01-01T00:00:00Z
+`,
+			want: plainProfile,
+		},
+		{
+			name:        "not migrated",
+			credentials: plainProfile,
+			wantErr:     true,
+		},
+		{
+			name:        "other keys in the temporary section",
+			credentials: migrated + "\n[work]\nregion = us-east-1\n",
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cleanup := writeCredentials(t, tt.credentials)
+			defer cleanup()
+
+			backup, err := Options{
+				CredentialsFileLocation: path,
+				Profile:                 "work",
+				ProfileSuffix:           "permanent",
+			}.UndoMigrateProfile()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UndoMigrateProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr {
+				if string(data) != tt.credentials {
+					t.Errorf("UndoMigrateProfile() changed the credentials file:\n%s", data)
+				}
+				return
+			}
+
+			if string(data) != tt.want {
+				t.Errorf("UndoMigrateProfile() wrote:\n%q\nwant:\n%q", data, tt.want)
+			}
+			if saved, err := ioutil.ReadFile(backup); err != nil || string(saved) != tt.credentials {
+				t.Errorf("the backup %s doesn't have the contents before undoing: %v", backup, err)
+			}
+		})
+	}
+}
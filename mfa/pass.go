@@ -0,0 +1,90 @@
+package mfa
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// PassKeyStore keeps access keys in pass, the standard unix password manager.
+// Each profile is an entry under Prefix with the secret access key on the
+// first line, as pass expects, and the access key ID on an
+// `aws_access_key_id:` line after it.
+type PassKeyStore struct {
+	// Prefix of the entries, e.g. aws-mfa for aws-mfa/work-permanent
+	Prefix string
+
+	// Command is the pass executable
+	Command string
+}
+
+// NewPassKeyStore returns a PassKeyStore using the pass on the PATH, with
+// entries under aws-mfa/.
+func NewPassKeyStore() PassKeyStore {
+	return PassKeyStore{Prefix: "aws-mfa", Command: "pass"}
+}
+
+func (s PassKeyStore) entry(profile string) string {
+	return s.Prefix + "/" + profile
+}
+
+func (s PassKeyStore) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(s.Command, args...)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+func (s PassKeyStore) AccessKey(profile string) (string, string, error) {
+	output, err := s.command("show", s.entry(profile)).Output()
+	if err != nil {
+		return "", "", fmt.Errorf("pass show %s failed: %v", s.entry(profile), err)
+	}
+	return parsePassEntry(s.entry(profile), string(output))
+}
+
+func (s PassKeyStore) SetAccessKey(profile, id, secret string) error {
+	cmd := s.command("insert", "--multiline", "--force", s.entry(profile))
+	cmd.Stdin = strings.NewReader(formatPassEntry(id, secret))
+	cmd.Stdout = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pass insert %s failed: %v", s.entry(profile), err)
+	}
+	return nil
+}
+
+func (s PassKeyStore) DeleteAccessKey(profile string) error {
+	cmd := s.command("rm", "--force", s.entry(profile))
+	cmd.Stdout = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pass rm %s failed: %v", s.entry(profile), err)
+	}
+	return nil
+}
+
+func formatPassEntry(id, secret string) string {
+	return fmt.Sprintf("%s\n%s: %s\n", secret, accessKeyIDKey, id)
+}
+
+// parsePassEntry reads the access key from the contents of an entry
+func parsePassEntry(entry, contents string) (string, string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+
+	var id, secret string
+	for line := 0; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if line == 0 {
+			secret = text
+			continue
+		}
+		if parts := strings.SplitN(text, ":", 2); len(parts) == 2 && strings.TrimSpace(parts[0]) == accessKeyIDKey {
+			id = strings.TrimSpace(parts[1])
+		}
+	}
+
+	if id == "" || secret == "" {
+		return "", "", fmt.Errorf("the pass entry %s needs the secret access key on the first line and an %s: line", entry, accessKeyIDKey)
+	}
+	return id, secret, nil
+}
@@ -0,0 +1,124 @@
+package mfa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Actions of the key store plugin protocol
+const (
+	PluginGet   = "get"
+	PluginStore = "store"
+	PluginErase = "erase"
+)
+
+// keyStoreCommandKey is the plugin command of a section with key_store = exec
+const keyStoreCommandKey = `key_store_command`
+
+// PluginRequest is written to the stdin of a key store plugin. The keys are
+// only set when storing.
+type PluginRequest struct {
+	Profile         string `json:"profile"`
+	AccessKeyID     string `json:"aws_access_key_id,omitempty"`
+	SecretAccessKey string `json:"aws_secret_access_key,omitempty"`
+}
+
+// PluginResponse is written to stdout by a key store plugin when getting a
+// key. Nothing is expected for the other actions.
+type PluginResponse struct {
+	AccessKeyID     string `json:"aws_access_key_id"`
+	SecretAccessKey string `json:"aws_secret_access_key"`
+}
+
+// execKeyStore keeps access keys with a plugin. The plugin is run with the
+// shell like a git credential helper, with the action appended to the
+// command: it reads a PluginRequest from stdin, writes a PluginResponse to
+// stdout for get, and exits with a non-zero status when the action fails.
+type execKeyStore struct {
+	command string
+}
+
+func (s execKeyStore) run(action string, request PluginRequest) ([]byte, error) {
+	logger := log.WithFields(logrus.Fields{"prefix": "keys", "command": s.command, "action": action})
+
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := shellCommand(s.command + " " + action)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+
+	logger.Debugln("Running the key store plugin")
+
+	output, err := cmd.Output()
+	if err != nil {
+		logger.WithError(err).Errorln("The key store plugin failed")
+		return nil, fmt.Errorf("%s %s failed for [%s]: %v", s.command, action, request.Profile, err)
+	}
+	return output, nil
+}
+
+func (s execKeyStore) AccessKey(profile string) (string, string, error) {
+	output, err := s.run(PluginGet, PluginRequest{Profile: profile})
+	if err != nil {
+		return "", "", err
+	}
+
+	var response PluginResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return "", "", fmt.Errorf("%s %s didn't output a valid response: %v", s.command, PluginGet, err)
+	}
+	if response.AccessKeyID == "" || response.SecretAccessKey == "" {
+		return "", "", fmt.Errorf("%s %s didn't output an access key for [%s]", s.command, PluginGet, profile)
+	}
+	return response.AccessKeyID, response.SecretAccessKey, nil
+}
+
+func (s execKeyStore) SetAccessKey(profile, id, secret string) error {
+	_, err := s.run(PluginStore, PluginRequest{Profile: profile, AccessKeyID: id, SecretAccessKey: secret})
+	return err
+}
+
+func (s execKeyStore) DeleteAccessKey(profile string) error {
+	_, err := s.run(PluginErase, PluginRequest{Profile: profile})
+	return err
+}
+
+// ServePlugin handles one action of the key store plugin protocol with store,
+// reading the request from in and writing the response to out. It's used to
+// build plugins on top of a KeyStore.
+func ServePlugin(store KeyStore, action string, in io.Reader, out io.Writer) error {
+	var request PluginRequest
+	if err := json.NewDecoder(in).Decode(&request); err != nil {
+		return fmt.Errorf("failed to read the request: %v", err)
+	}
+	if strings.TrimSpace(request.Profile) == "" {
+		return fmt.Errorf("the request has no profile")
+	}
+
+	switch action {
+	case PluginGet:
+		id, secret, err := store.AccessKey(request.Profile)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(out).Encode(PluginResponse{AccessKeyID: id, SecretAccessKey: secret})
+	case PluginStore:
+		if request.AccessKeyID == "" || request.SecretAccessKey == "" {
+			return fmt.Errorf("the request has no access key to store")
+		}
+		return store.SetAccessKey(request.Profile, request.AccessKeyID, request.SecretAccessKey)
+	case PluginErase:
+		return store.DeleteAccessKey(request.Profile)
+	default:
+		return fmt.Errorf("unknown action %q, expected %s, %s or %s", action, PluginGet, PluginStore, PluginErase)
+	}
+}
@@ -0,0 +1,236 @@
+package mfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-ini/ini"
+)
+
+// pluginFileEnvVar tells the test binary to act as a key store plugin that
+// keeps its keys in the named JSON file
+const pluginFileEnvVar = "AWS_MFA_TEST_PLUGIN_FILE"
+
+// fileKeyStore keeps access keys in a JSON file, for the test plugin
+type fileKeyStore string
+
+func (s fileKeyStore) read() map[string]PluginResponse {
+	keys := map[string]PluginResponse{}
+	if data, err := ioutil.ReadFile(string(s)); err == nil {
+		json.Unmarshal(data, &keys)
+	}
+	return keys
+}
+
+func (s fileKeyStore) write(keys map[string]PluginResponse) error {
+	data, _ := json.Marshal(keys)
+	return ioutil.WriteFile(string(s), data, 0600)
+}
+
+func (s fileKeyStore) AccessKey(profile string) (string, string, error) {
+	key, ok := s.read()[profile]
+	if !ok {
+		return "", "", fmt.Errorf("no access key for %s", profile)
+	}
+	return key.AccessKeyID, key.SecretAccessKey, nil
+}
+
+func (s fileKeyStore) SetAccessKey(profile, id, secret string) error {
+	keys := s.read()
+	keys[profile] = PluginResponse{AccessKeyID: id, SecretAccessKey: secret}
+	return s.write(keys)
+}
+
+func (s fileKeyStore) DeleteAccessKey(profile string) error {
+	keys := s.read()
+	delete(keys, profile)
+	return s.write(keys)
+}
+
+// TestPluginHelper isn't a real test, the other tests run the test binary as
+// a key store plugin and this handles the action given to it
+func TestPluginHelper(t *testing.T) {
+	path := os.Getenv(pluginFileEnvVar)
+	if path == "" {
+		return
+	}
+
+	action := os.Args[len(os.Args)-1]
+	if err := ServePlugin(fileKeyStore(path), action, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// testPlugin returns the command that runs the test binary as a plugin, and
+// the file it keeps its keys in
+func testPlugin(t *testing.T, dir string) (string, fileKeyStore) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the plugin is run with sh")
+	}
+
+	path := filepath.Join(dir, "plugin.json")
+	os.Setenv(pluginFileEnvVar, path)
+	return fmt.Sprintf("'%s' -test.run=TestPluginHelper --", os.Args[0]), fileKeyStore(path)
+}
+
+func TestExecKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-mfa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	command, file := testPlugin(t, dir)
+	defer os.Unsetenv(pluginFileEnvVar)
+	store := execKeyStore{command: command}
+
+	if _, _, err := store.AccessKey("work-permanent"); err == nil {
+		t.Fatal("AccessKey() of a missing profile should fail")
+	}
+
+	if err := store.SetAccessKey("work-permanent", "AKIAWORK", "work-secret"); err != nil {
+		t.Fatalf("SetAccessKey() error = %v", err)
+	}
+	if got := file.read()["work-permanent"]; got.AccessKeyID != "AKIAWORK" || got.SecretAccessKey != "work-secret" {
+		t.Errorf("the plugin stored %+v", got)
+	}
+
+	id, secret, err := store.AccessKey("work-permanent")
+	if err != nil {
+		t.Fatalf("AccessKey() error = %v", err)
+	}
+	if id != "AKIAWORK" || secret != "work-secret" {
+		t.Errorf("AccessKey() = %s, %s, want AKIAWORK, work-secret", id, secret)
+	}
+
+	if err := store.DeleteAccessKey("work-permanent"); err != nil {
+		t.Fatalf("DeleteAccessKey() error = %v", err)
+	}
+	if _, ok := file.read()["work-permanent"]; ok {
+		t.Error("the access key wasn't erased")
+	}
+}
+
+func TestImportAccessKeyExec(t *testing.T) {
+	path, cleanup := writeCredentials(t, permanentSection("mfa_serial = "+testSerial))
+	defer cleanup()
+
+	command, file := testPlugin(t, filepath.Dir(path))
+	defer os.Unsetenv(pluginFileEnvVar)
+
+	options := Options{
+		CredentialsFileLocation: path,
+		Profile:                 "default",
+		ProfileSuffix:           "permanent",
+		Token:                   "123456",
+	}
+	config, err := options.Validate()
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if err := config.ImportAccessKey(ExecKeyStore, ""); err == nil {
+		t.Error("ImportAccessKey() without a command should fail")
+	}
+	if err := config.ImportAccessKey(ExecKeyStore, command); err != nil {
+		t.Fatalf("ImportAccessKey() error = %v", err)
+	}
+	config.Close()
+
+	if got := file.read()["default-permanent"]; got.AccessKeyID != "AKIAPERMANENT" {
+		t.Errorf("the plugin stored %+v", got)
+	}
+	saved, err := ini.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	section := saved.Section("default-permanent")
+	if section.HasKey(accessKeyIDKey) || section.HasKey(secretAccessKey) {
+		t.Error("the access key is still in the credentials file")
+	}
+	if got := section.Key(keyStoreCommandKey).String(); got != command {
+		t.Errorf("%s = %q, want %q", keyStoreCommandKey, got, command)
+	}
+
+	config, err = options.Validate()
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	defer config.Close()
+
+	fake := &fakeSTS{}
+	refresher, _ := NewRefresher(config, fake.factory)
+	if err := refresher.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if len(fake.accessKeys) == 0 || fake.accessKeys[0] != "AKIAPERMANENT" {
+		t.Errorf("STS clients were created with %v, want AKIAPERMANENT first", fake.accessKeys)
+	}
+}
+
+func TestServePlugin(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  string
+		request string
+		wantErr bool
+	}{
+		{name: "unknown action", action: "list", request: `{"profile": "work"}`, wantErr: true},
+		{name: "no profile", action: PluginGet, request: `{}`, wantErr: true},
+		{name: "invalid request", action: PluginGet, request: `profile=work`, wantErr: true},
+		{name: "store without a key", action: PluginStore, request: `{"profile": "work"}`, wantErr: true},
+		{name: "store", action: PluginStore, request: `{"profile": "work", "aws_access_key_id": "AKIAWORK", "aws_secret_access_key": "work-secret"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "aws-mfa")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			var out strings.Builder
+			err = ServePlugin(fileKeyStore(filepath.Join(dir, "keys.json")), tt.action, strings.NewReader(tt.request), &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ServePlugin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParsePassEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		wantID   string
+		wantErr  bool
+	}{
+		{name: "written by aws-mfa", contents: formatPassEntry("AKIAWORK", "work-secret"), wantID: "AKIAWORK"},
+		{name: "other fields", contents: "work-secret\nurl: https://console.aws.amazon.com\naws_access_key_id:AKIAWORK\n", wantID: "AKIAWORK"},
+		{name: "no access key id", contents: "work-secret\n", wantErr: true},
+		{name: "empty", contents: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, secret, err := parsePassEntry("aws-mfa/work", tt.contents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePassEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if id != tt.wantID || secret != "work-secret" {
+				t.Errorf("parsePassEntry() = %s, %s, want %s, work-secret", id, secret, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package mfa
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// DefaultProcessCacheFile returns where the temporary credentials printed for
+// credential_process are kept between runs, next to the credentials file.
+// They can't go in the credentials file itself, the CLI would read them from
+// there and stop running the process once they expire.
+func DefaultProcessCacheFile(credentialsFile string) string {
+	return filepath.Join(filepath.Dir(credentialsFile), "aws-mfa-process")
+}
+
+// ProcessCredentials is the document a credential_process is expected to print,
+// see https://docs.aws.amazon.com/cli/latest/topic/config-vars.html#sourcing-credentials-from-external-processes
+type ProcessCredentials struct {
+	Version         int
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      string `json:",omitempty"`
+}
+
+// ProcessCredentials refreshes the temporary credentials if needed and returns
+// them in the credential_process format. Load the config with ProcessCache set
+// so they're kept out of the credentials file.
+func (r Refresher) ProcessCredentials() (*ProcessCredentials, error) {
+	if err := r.Refresh(); err != nil {
+		return nil, err
+	}
+
+	credentials, expires, err := r.Credentials()
+	if err != nil {
+		r.log.WithError(err).Errorln("Failed to read the temporary credentials")
+		return nil, err
+	}
+
+	return &ProcessCredentials{
+		Version:         1,
+		AccessKeyID:     credentials.AccessKeyID,
+		SecretAccessKey: credentials.SecretAccessKey,
+		SessionToken:    credentials.SessionToken,
+		Expiration:      expires.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// newProcessCredentials formats credentials from STS for credential_process
+func newProcessCredentials(credentials *sts.Credentials) *ProcessCredentials {
+	return &ProcessCredentials{
+		Version:         1,
+		AccessKeyID:     aws.StringValue(credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(credentials.SessionToken),
+		Expiration:      aws.TimeValue(credentials.Expiration).UTC().Format(time.RFC3339),
+	}
+}
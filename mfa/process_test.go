@@ -0,0 +1,106 @@
+package mfa
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+func TestProcessCredentials(t *testing.T) {
+	path, cleanup := writeCredentials(t, permanentSection("mfa_serial = "+testSerial))
+	defer cleanup()
+
+	config, err := Options{
+		CredentialsFileLocation: path,
+		Profile:                 "default",
+		ProfileSuffix:           "permanent",
+		ProcessCache:            true,
+	}.Validate()
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	defer config.Close()
+
+	fake := &fakeSTS{expiration: time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))}
+	refresher, _ := NewRefresher(config, fake.factory)
+	refresher.tokens = StaticToken("123456")
+
+	credentials, err := refresher.ProcessCredentials()
+	if err != nil {
+		t.Fatalf("ProcessCredentials() error = %v", err)
+	}
+
+	// the field names and the version are what the SDKs and CLI expect
+	want := `{"Version":1,"AccessKeyId":"ASIAFAKEACCESSKEYID","SecretAccessKey":"fake-secret-access-key","SessionToken":"fake-session-token","Expiration":"2030-01-02T02:04:05Z"}`
+
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("ProcessCredentials() =\n%s\nwant\n%s", data, want)
+	}
+
+	fromSTS := fake.credentials(nil)
+	if data, err = json.Marshal(newProcessCredentials(fromSTS)); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("newProcessCredentials() =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestProcessCredentialsCache(t *testing.T) {
+	credentials := permanentSection("mfa_serial = " + testSerial)
+	path, cleanup := writeCredentials(t, credentials)
+	defer cleanup()
+
+	options := Options{
+		CredentialsFileLocation: path,
+		Profile:                 "default",
+		ProfileSuffix:           "permanent",
+		ProcessCache:            true,
+	}
+
+	fake := &fakeSTS{}
+	for run := 1; run <= 2; run++ {
+		config, err := options.Validate()
+		if err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+
+		refresher, _ := NewRefresher(config, fake.factory)
+		refresher.tokens = StaticToken("123456")
+		if _, err := refresher.ProcessCredentials(); err != nil {
+			t.Fatalf("run %d: ProcessCredentials() error = %v", run, err)
+		}
+		config.Close()
+	}
+
+	if fake.calls() != 1 {
+		t.Errorf("STS calls = %d, want 1, the cached credentials should be reused", fake.calls())
+	}
+
+	// the CLI reads the credentials file before running credential_process,
+	// so the temporary section mustn't end up there
+	if got, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(got, []byte(credentials)) {
+		t.Errorf("the credentials file changed:\n%s", got)
+	}
+
+	cache, err := ini.Load(DefaultProcessCacheFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cache.Section("default").Key(accessKeyIDKey).String(); got != "ASIAFAKEACCESSKEYID" {
+		t.Errorf("%s in the cache = %q, want ASIAFAKEACCESSKEYID", accessKeyIDKey, got)
+	}
+
+	options.OutputFileLocation = path
+	if _, err := options.Validate(); err == nil {
+		t.Error("Validate() should fail when the output file is the credentials file")
+	}
+}
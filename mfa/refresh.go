@@ -20,9 +20,37 @@ const (
 	// Optional
 	sessionTokenKey = `aws_session_token` // optional
 
+	// Assume Role group
+	roleArnKey         = `role_arn`          // group required
+	sourceProfileKey   = `source_profile`    // optional, defaults to the permanent profile
+	externalIDKey      = `external_id`       // optional
+	roleSessionNameKey = `role_session_name` // optional
+	durationSecondsKey = `duration_seconds`  // optional
+
+	// Additional config fields
+	regionKey = `region`
+
 	// Our additional keys
-	mfaSerialKey = `mfa_serial`
-	expiresKey   = `expires`
+	mfaSerialKey     = `mfa_serial`
+	mfaProcessKey    = `mfa_process`
+	refreshBeforeKey = `refresh_before`
+	expiresKey       = `expires`
+	issuedKey        = `issued`
+
+	// tempCredentialsFileKey is a file the temporary section is written to
+	// instead of the credentials file
+	tempCredentialsFileKey = `temp_credentials_file`
+
+	// Identity of the temporary credentials, checked against the expected account
+	accountIDKey         = `account_id`
+	callerARNKey         = `caller_arn`
+	expectedAccountIDKey = `expected_account_id`
+)
+
+const (
+	// Default durations of the temporary credentials when none are given
+	defaultSessionDuration = time.Hour * 36
+	defaultRoleDuration    = time.Hour
 )
 
 var log = logrus.New()
@@ -47,97 +75,30 @@ func NewAWSDebugLogger(from *logrus.Entry) AWSDebugLogger {
 	}
 }
 
-type ConfigValue struct {
-	Profile string
-	Section *ini.Section
-}
-
-type Config struct {
-	Options Options
-
-	Permanent ConfigValue
-	Temporary ConfigValue
-
-	CredentialsFile *ini.File
-}
+type Refresher struct {
+	log    *logrus.Entry
+	Config *Config
 
-type Options struct {
-	CredentialsFileLocation string
-	Profile                 string
-	ProfileSuffix           string
-	Duration                time.Duration
-	MFASerial               string
-	Force                   bool
-	Verbose                 bool
+	newSTS STSFactory
+	tokens TokenProvider
+	now    func() time.Time
 }
 
-func (o Options) Validate() (*Config, error) {
-	logger := log.WithField("prefix", "options")
-
-	if o.Verbose {
-		log.SetLevel(logrus.DebugLevel)
-	}
-
-	logger.Debugln("Validating options")
-
-	logger.WithFields(logrus.Fields{
-		"--credentials": o.CredentialsFileLocation,
-		"--profile":     o.Profile,
-		"--suffix":      o.ProfileSuffix,
-		"--duration":    o.Duration,
-		"--mfa":         o.MFASerial,
-		"--force":       o.Force,
-		"--verbose":     o.Verbose,
-	}).Debugln("Using the following options")
-
-	permanentProfile := o.Profile + "-" + o.ProfileSuffix
-
-	credentialsFile, err := ini.Load(o.CredentialsFileLocation)
+// NewRefresher creates a Refresher that uses newSTS to create the STS client,
+// pass NewSTS to talk to AWS.
+func NewRefresher(c *Config, newSTS STSFactory) (*Refresher, error) {
+	tokens, err := NewTokenProvider(c)
 	if err != nil {
-		logger.WithError(err).Fatalln("Failed to load the credentials file")
+		log.WithField("prefix", "refresher").WithError(err).Errorln("Failed to configure the MFA token provider")
 		return nil, err
 	}
 
-	perm, err := credentialsFile.GetSection(permanentProfile)
-	if err != nil {
-		logger.Errorln("Failed to read permanent credentials section")
-		return nil, err
-	}
-
-	temp, err := credentialsFile.GetSection(o.Profile)
-	if err != nil {
-		logger.Debugln("Failed to read temporary credentials section, creating one")
-		temp = credentialsFile.Section(o.Profile)
-	}
-
-	if o.MFASerial == "" && perm.HasKey(mfaSerialKey) {
-		o.MFASerial = perm.Key(mfaSerialKey).String()
-	}
-
-	return &Config{
-		Options: o,
-
-		Permanent: ConfigValue{
-			Profile: permanentProfile,
-			Section: perm,
-		},
-		Temporary: ConfigValue{
-			Profile: o.Profile,
-			Section: temp,
-		},
-		CredentialsFile: credentialsFile,
-	}, nil
-}
-
-type Refresher struct {
-	log    *logrus.Entry
-	Config *Config
-}
-
-func NewRefresher(c *Config) (*Refresher, error) {
 	return &Refresher{
 		log:    log.WithField("prefix", "refresher"),
 		Config: c,
+		newSTS: newSTS,
+		tokens: tokens,
+		now:    time.Now,
 	}, nil
 }
 
@@ -145,7 +106,8 @@ func (r Refresher) GetMFAToken() (string, error) {
 	device := r.Config.Options.MFASerial
 	if device == "" {
 		r.log.Printf("No MFA serial found, please enter one")
-		if _, err := fmt.Scanln(&device); err != nil {
+		var err error
+		if device, err = (TerminalToken{}).readLine(); err != nil {
 			r.log.Errorln("Can't continue without a MFA serial")
 			return "", err
 		}
@@ -153,10 +115,7 @@ func (r Refresher) GetMFAToken() (string, error) {
 
 	r.Config.Options.MFASerial = device
 
-	var token string
-	r.log.Printf("Enter the MFA token code for device %s", device)
-	_, err := fmt.Scanln(&token)
-	return token, err
+	return r.tokens.Token(device)
 }
 
 func (r Refresher) Clear(removeMfa bool) error {
@@ -171,8 +130,11 @@ func (r Refresher) Clear(removeMfa bool) error {
 	r.Config.Temporary.Section.DeleteKey(secretAccessKey)
 	r.Config.Temporary.Section.DeleteKey(sessionTokenKey)
 	r.Config.Temporary.Section.DeleteKey(expiresKey)
+	r.Config.Temporary.Section.DeleteKey(issuedKey)
+	r.Config.Temporary.Section.DeleteKey(accountIDKey)
+	r.Config.Temporary.Section.DeleteKey(callerARNKey)
 
-	if err := r.Config.CredentialsFile.SaveTo(r.Config.Options.CredentialsFileLocation); err != nil {
+	if err := r.Config.Save(); err != nil {
 		r.log.WithError(err).Errorln("Failed to clear the temporary credentials")
 		return err
 	}
@@ -181,10 +143,15 @@ func (r Refresher) Clear(removeMfa bool) error {
 }
 
 func (r Refresher) Save(credentials *sts.Credentials) error {
-	if r.Config.Options.MFASerial != "" {
+	// don't copy a serial that lives in the shared config file into the credentials file,
+	// or write to it at all when the temporary credentials go to an output file
+	fromSharedConfig := !r.Config.Permanent.Section.HasKey(mfaSerialKey) &&
+		r.Config.Options.MFASerial == r.Config.SharedConfigValues().String(mfaSerialKey)
+
+	if r.Config.Options.MFASerial != "" && !fromSharedConfig && !r.Config.permanentReadOnly() {
 		oldSerial := r.Config.Permanent.Section.Key(mfaSerialKey).String()
 		newSerial := r.Config.Options.MFASerial
-		if oldSerial != newSerial  {
+		if oldSerial != newSerial {
 			r.log.WithFields(logrus.Fields{"old": oldSerial, "new": newSerial}).Infoln("Updating saved MFA serial")
 		} else {
 			r.log.Infoln("Saving MFA serial to permanent section")
@@ -198,8 +165,9 @@ func (r Refresher) Save(credentials *sts.Credentials) error {
 	r.Config.Temporary.Section.Key(secretAccessKey).SetValue(aws.StringValue(credentials.SecretAccessKey))
 	r.Config.Temporary.Section.Key(sessionTokenKey).SetValue(aws.StringValue(credentials.SessionToken))
 	r.Config.Temporary.Section.Key(expiresKey).SetValue(aws.TimeValue(credentials.Expiration).Local().Format(time.RFC3339))
+	r.Config.Temporary.Section.Key(issuedKey).SetValue(r.now().Format(time.RFC3339))
 
-	if err := r.Config.CredentialsFile.SaveTo(r.Config.Options.CredentialsFileLocation); err != nil {
+	if err := r.Config.Save(); err != nil {
 		r.log.Errorln("Failed to save the temporary credentials")
 		return err
 	}
@@ -207,72 +175,412 @@ func (r Refresher) Save(credentials *sts.Credentials) error {
 	return nil
 }
 
-func (r Refresher) Refresh() error {
-	expires := time.Now()
-	if r.Config.Temporary.Section.HasKey(expiresKey) {
-		expires, _ = r.Config.Temporary.Section.Key(expiresKey).Time()
+// Credentials returns the temporary credentials stored in the temporary
+// section along with when they expire.
+func (r Refresher) Credentials() (aws.Credentials, time.Time, error) {
+	section := r.Config.Temporary.Section
+
+	for _, key := range []string{accessKeyIDKey, secretAccessKey, sessionTokenKey, expiresKey} {
+		if !section.HasKey(key) {
+			return aws.Credentials{}, time.Time{}, fmt.Errorf("temporary section [%s] is missing %s", r.Config.Temporary.Profile, key)
+		}
 	}
 
-	// only refresh if force is set or if the credentials are expired
-	if r.Config.Options.Force || expires.Before(time.Now().Add(time.Hour)) {
-		r.log.WithField("profile", r.Config.Options.Profile).Infoln("Refreshing temporary credentials")
+	expires, err := section.Key(expiresKey).Time()
+	if err != nil {
+		return aws.Credentials{}, time.Time{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     section.Key(accessKeyIDKey).String(),
+		SecretAccessKey: section.Key(secretAccessKey).String(),
+		SessionToken:    section.Key(sessionTokenKey).String(),
+		Source:          fmt.Sprintf("aws-mfa: %s", r.Config.Temporary.Profile),
+	}, expires, nil
+}
 
-		awsConfig, err := external.LoadDefaultAWSConfig(
-			external.WithSharedConfigProfile(r.Config.Permanent.Profile),
-			external.WithSharedConfigFiles([]string{r.Config.Options.CredentialsFileLocation}),
-		)
+// loadAWSConfig builds the AWS config used to call STS from the static
+// credentials in the source section, rather than letting the SDK resolve the
+// profile so that role_arn and source_profile are left for us to handle. If
+// session is given it's used instead of the static credentials.
+func (r Refresher) loadAWSConfig(session *sts.Credentials) (aws.Config, error) {
+	section := r.Config.Source.Section
+
+	var credentials aws.Credentials
+	if session != nil {
+		credentials = aws.Credentials{
+			AccessKeyID:     aws.StringValue(session.AccessKeyId),
+			SecretAccessKey: aws.StringValue(session.SecretAccessKey),
+			SessionToken:    aws.StringValue(session.SessionToken),
+			Source:          "aws-mfa: mfa session",
+		}
+	} else {
+		id, secret, err := r.Config.AccessKey()
 		if err != nil {
-			r.log.Errorln("Failed to load your credentials")
-			return err
+			return aws.Config{}, err
 		}
 
-		awsConfig.Logger = NewAWSDebugLogger(r.log)
-		if r.Config.Options.Verbose  {
-			awsConfig.LogLevel = aws.LogDebugWithSigning
+		credentials = aws.Credentials{
+			AccessKeyID:     id,
+			SecretAccessKey: secret,
+			Source:          fmt.Sprintf("aws-mfa: %s", r.Config.Source.Profile),
+		}
+		if section.HasKey(sessionTokenKey) {
+			credentials.SessionToken = section.Key(sessionTokenKey).String()
 		}
+	}
 
-		svc := sts.New(awsConfig)
+	configs := external.Configs{
+		external.WithCredentialsValue(credentials),
+	}
+	if region := r.Config.Settings.String(regionKey); region != "" {
+		configs = append(configs, external.WithRegion(region))
+	}
 
-		// build the request to send to STS
-		input := &sts.GetSessionTokenInput{
-			DurationSeconds: aws.Int64(int64(r.Config.Options.Duration.Seconds())),
-		}
+	configs, err := configs.AppendFromLoaders([]external.ConfigLoader{external.LoadEnvConfig})
+	if err != nil {
+		return aws.Config{}, err
+	}
 
-		if r.Config.Options.MFASerial != "" {
-			var token string
-			token, err = r.GetMFAToken()
-			if err != nil {
-				r.log.WithError(err).Fatalln("Couldn't read your MFA token")
-			}
-			input.SerialNumber = aws.String(r.Config.Options.MFASerial)
-			input.TokenCode = aws.String(token)
-		} else {
-			r.log.Warnln("No MFA Serial provided, your temporary credentials may not work as expected")
-			r.log.Infoln("Use --mfa to provide an MFA device")
+	return configs.ResolveAWSConfig(external.DefaultAWSConfigResolvers)
+}
+
+// client creates the STS client, signing with session if given
+func (r Refresher) client(session *sts.Credentials) (STS, error) {
+	awsConfig, err := r.awsConfig(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.newSTS(awsConfig), nil
+}
+
+// awsConfig loads the config for a client and sets up its logging
+func (r Refresher) awsConfig(session *sts.Credentials) (aws.Config, error) {
+	awsConfig, err := r.loadAWSConfig(session)
+	if err != nil {
+		r.log.Errorln("Failed to load your credentials")
+		return aws.Config{}, err
+	}
+
+	awsConfig.Logger = NewAWSDebugLogger(r.log)
+	if r.Config.Options.Verbose {
+		awsConfig.LogLevel = aws.LogDebugWithSigning
+	}
+
+	return awsConfig, nil
+}
+
+// mfaToken returns the serial and token code to send to STS, both are nil
+// when there's no MFA device
+func (r Refresher) mfaToken() (serial, token *string, err error) {
+	if r.Config.Options.MFASerial == "" {
+		r.log.Warnln("No MFA Serial provided, your temporary credentials may not work as expected")
+		r.log.Infoln("Use --mfa to provide an MFA device")
+		return nil, nil, nil
+	}
+
+	code, err := r.GetMFAToken()
+	if err != nil {
+		r.log.WithError(err).Errorln("Couldn't read your MFA token")
+		return nil, nil, err
+	}
+
+	return aws.String(r.Config.Options.MFASerial), aws.String(code), nil
+}
+
+func (r Refresher) getSessionToken(svc STS, duration time.Duration, serial, token *string) (*sts.Credentials, error) {
+	input := &sts.GetSessionTokenInput{
+		DurationSeconds: aws.Int64(int64(duration.Seconds())),
+		SerialNumber:    serial,
+		TokenCode:       token,
+	}
+
+	resp, err := svc.GetSessionToken(input)
+	if err != nil {
+		r.log.WithError(err).Errorln("Failed to get session token from STS")
+		return nil, err
+	}
+
+	return resp.Credentials, nil
+}
+
+func (r Refresher) assumeRole(svc STS, duration time.Duration, serial, token *string) (*sts.Credentials, error) {
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(r.Config.Options.RoleARN),
+		RoleSessionName: aws.String(r.Config.Options.RoleSessionName),
+		DurationSeconds: aws.Int64(int64(duration.Seconds())),
+		SerialNumber:    serial,
+		TokenCode:       token,
+	}
+	if r.Config.Options.ExternalID != "" {
+		input.ExternalId = aws.String(r.Config.Options.ExternalID)
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"role":    r.Config.Options.RoleARN,
+		"session": r.Config.Options.RoleSessionName,
+	}).Debugln("Assuming role")
+
+	resp, err := svc.AssumeRole(input)
+	if err != nil {
+		r.log.WithError(err).Errorln("Failed to assume role with STS")
+		return nil, err
+	}
+
+	return resp.Credentials, nil
+}
+
+// NeedsRefresh returns true if force is set or the temporary credentials
+// have less time left than the refresh threshold, along with when they expire.
+func (r Refresher) NeedsRefresh() (bool, time.Time) {
+	section := r.Config.Temporary.Section
+
+	now := r.now()
+	expires := now
+	if section.HasKey(expiresKey) {
+		expires, _ = section.Key(expiresKey).Time()
+	}
+
+	// credentials saved by older versions don't have an issue time
+	var issued time.Time
+	if section.HasKey(issuedKey) {
+		issued, _ = section.Key(issuedKey).Time()
+	}
+
+	remaining := r.Config.Options.RefreshBefore.Remaining(issued, expires)
+
+	return r.Config.Options.Force || expires.Before(now.Add(remaining)), expires
+}
+
+func (r Refresher) Refresh() error {
+	// only refresh if force is set or if the credentials are expired
+	if ok, expires := r.NeedsRefresh(); !ok {
+		r.log.Println("Already have credentials that expire in", expires.Sub(r.now()))
+		r.log.Infoln("Use --force to update anyways")
+		return nil
+	}
+
+	if err := r.checkKeyAge(); err != nil {
+		return err
+	}
+
+	r.log.WithField("profile", r.Config.Options.Profile).Infoln("Refreshing temporary credentials")
+
+	svc, err := r.client(nil)
+	if err != nil {
+		return err
+	}
+
+	// send the request to STS
+	credentials, err := r.withToken(func(serial, token *string) (*sts.Credentials, error) {
+		if r.Config.Options.AssumesRole() {
+			return r.assumeRole(svc, r.Config.Options.Duration, serial, token)
 		}
+		return r.getSessionToken(svc, r.Config.Options.Duration, serial, token)
+	})
+	if err != nil {
+		return r.failed(err)
+	}
+
+	return r.saveRefreshed(credentials)
+}
 
-		// send the request to STS
-		req := svc.GetSessionTokenRequest(input)
-		resp, err := req.Send()
+// withToken sends a request with the MFA serial and a token code. When the
+// code is rejected and was typed in, it's asked for again up to the number of
+// attempts allowed.
+func (r Refresher) withToken(request func(serial, token *string) (*sts.Credentials, error)) (*sts.Credentials, error) {
+	_, prompted := r.tokens.(TerminalToken)
+
+	// getting a token code can take a while, don't let others time out
+	r.Config.refreshing()
+
+	for attempt := 1; ; attempt++ {
+		serial, token, err := r.mfaToken()
 		if err != nil {
-			r.log.WithError(err).Errorln("Failed to get session token from STS")
-			r.Clear(false)
-			return err
+			return nil, err
 		}
 
-		// save the temporary credentials to the credentials file
-		if err := r.Save(resp.Credentials); err != nil {
+		credentials, err := request(serial, token)
+		if err == nil || serial == nil || !prompted || attempt >= r.Config.Options.MFAAttempts || ClassifyError(err) != InvalidTokenError {
+			return credentials, err
+		}
+
+		r.log.WithField("attempt", attempt).Warnln("The token code was rejected, try again")
+	}
+}
+
+// failed explains why a refresh failed. The temporary credentials are only
+// cleared when asked to, since they may well still be valid, and if that fails
+// too both errors are returned.
+func (r Refresher) failed(err error) error {
+	class := ClassifyError(err)
+
+	logger := r.log.WithFields(logrus.Fields{"profile": r.Config.Options.Profile, "reason": class})
+	if hint := class.hint(); hint != "" {
+		logger.Warnln(hint)
+	}
+
+	if r.Config.Options.ClearOnFailure {
+		if cerr := r.Clear(false); cerr != nil {
+			return fmt.Errorf("%v, and the temporary credentials couldn't be cleared: %v", err, cerr)
+		}
+		return err
+	}
+
+	if _, expires, cerr := r.Credentials(); cerr == nil && expires.After(r.now()) {
+		logger.Infoln("Keeping the existing credentials, they expire in", expires.Sub(r.now()).Round(time.Second))
+	}
+
+	return err
+}
+
+// SessionToken requests a session token with the MFA device. The session can
+// be passed to RefreshFrom to refresh several profiles with one token code,
+// since a code can't be used twice.
+func (r Refresher) SessionToken(duration time.Duration) (*sts.Credentials, error) {
+	svc, err := r.client(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.withToken(func(serial, token *string) (*sts.Credentials, error) {
+		return r.getSessionToken(svc, duration, serial, token)
+	})
+}
+
+// RefreshFrom refreshes the temporary credentials from an MFA session instead
+// of prompting for a token code. Roles are assumed using the session,
+// otherwise the session itself is saved.
+func (r Refresher) RefreshFrom(session *sts.Credentials) error {
+	r.log.WithField("profile", r.Config.Options.Profile).Infoln("Refreshing temporary credentials from the MFA session")
+
+	if err := r.checkKeyAge(); err != nil {
+		return err
+	}
+
+	credentials, err := r.credentialsFrom(session)
+	if err != nil {
+		return r.failed(err)
+	}
+
+	return r.saveRefreshed(credentials)
+}
+
+// credentialsFrom returns the credentials for the profile given an MFA
+// session, which is the session itself unless a role is assumed
+func (r Refresher) credentialsFrom(session *sts.Credentials) (*sts.Credentials, error) {
+	if !r.Config.Options.AssumesRole() {
+		return session, nil
+	}
+
+	svc, err := r.client(session)
+	if err != nil {
+		return nil, err
+	}
+
+	// assuming a role with the session counts as role chaining
+	duration, _ := checkDuration(r.Config.Options.Duration, "the role duration", true, true, true)
+
+	return r.assumeRole(svc, duration, nil, nil)
+}
+
+// saveRefreshed saves the new credentials to the temporary section and
+// verifies them, putting the previous credentials back if they don't work
+func (r Refresher) saveRefreshed(credentials *sts.Credentials) error {
+	previous := r.temporaryValues()
+
+	if err := r.Save(credentials); err != nil {
+		return err
+	}
+
+	if !r.Config.Options.SkipVerify {
+		if err := r.verify(credentials); err != nil {
+			r.log.WithError(err).Errorln("Failed to verify the new credentials, restoring the previous ones")
+			r.restoreTemporary(previous)
 			return err
 		}
+	}
 
-		r.log.WithFields(logrus.Fields{
-			"expires": time.Until(resp.Credentials.Expiration.Local()),
-			"profile": r.Config.Options.Profile,
-		}).Println("Successfully refreshed your temporary credentials")
-	} else {
-		r.log.Println("Already have credentials that expire in", time.Until(expires))
-		r.log.Infoln("Use --force to update anyways")
+	r.log.WithFields(logrus.Fields{
+		"expires": time.Until(credentials.Expiration.Local()),
+		"profile": r.Config.Options.Profile,
+	}).Println("Successfully refreshed your temporary credentials")
+
+	return nil
+}
+
+// AccountMismatchError is returned when refreshed credentials belong to a
+// different account than the one expected for the profile.
+type AccountMismatchError struct {
+	Profile  string
+	Expected string
+	Actual   string
+}
+
+func (e *AccountMismatchError) Error() string {
+	return fmt.Sprintf("the credentials for %s belong to account %s, expected %s", e.Profile, e.Actual, e.Expected)
+}
+
+// verify checks the credentials work and belong to the expected account, and
+// records who they belong to in the temporary section
+func (r Refresher) verify(credentials *sts.Credentials) error {
+	identity, err := r.CallerIdentity(credentials)
+	if err != nil {
+		return err
+	}
+
+	account, arn := aws.StringValue(identity.Account), aws.StringValue(identity.Arn)
+	r.log.WithFields(logrus.Fields{"account": account, "arn": arn}).Infoln("Verified the new credentials")
+
+	if expected := r.Config.Settings.String(expectedAccountIDKey); expected != "" && expected != account {
+		return &AccountMismatchError{Profile: r.Config.Options.Profile, Expected: expected, Actual: account}
 	}
 
+	r.Config.Temporary.Section.Key(accountIDKey).SetValue(account)
+	r.Config.Temporary.Section.Key(callerARNKey).SetValue(arn)
+
+	return r.Config.Save()
+}
+
+// temporaryKeys are the keys written to the temporary section
+var temporaryKeys = []string{accessKeyIDKey, secretAccessKey, sessionTokenKey, expiresKey, issuedKey, accountIDKey, callerARNKey}
+
+// temporaryValues returns the keys in the temporary section that are set
+func (r Refresher) temporaryValues() map[string]string {
+	return sectionValues(r.Config.Temporary.Section, temporaryKeys)
+}
+
+// restoreTemporary puts the temporary section back the way it was
+func (r Refresher) restoreTemporary(values map[string]string) error {
+	restoreSection(r.Config.Temporary.Section, temporaryKeys, values)
+
+	if err := r.Config.Save(); err != nil {
+		r.log.WithError(err).Errorln("Failed to restore the previous credentials")
+		return err
+	}
 	return nil
 }
+
+// sectionValues returns the keys in the section that are set
+func sectionValues(section *ini.Section, keys []string) map[string]string {
+	values := map[string]string{}
+	for _, key := range keys {
+		if section.HasKey(key) {
+			values[key] = section.Key(key).String()
+		}
+	}
+	return values
+}
+
+// restoreSection sets the keys to the values returned by sectionValues,
+// removing the ones that weren't set
+func restoreSection(section *ini.Section, keys []string, values map[string]string) {
+	for _, key := range keys {
+		if value, ok := values[key]; ok {
+			section.Key(key).SetValue(value)
+		} else {
+			section.DeleteKey(key)
+		}
+	}
+}
@@ -0,0 +1,672 @@
+package mfa
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/go-ini/ini"
+)
+
+const testSerial = "arn:aws:iam::123456789012:mfa/test"
+
+// invalidTokenErr is what STS returns for a wrong token code
+var invalidTokenErr = awserr.New("AccessDenied", "MultiFactorAuthentication failed with invalid MFA one time pass code. ", nil)
+
+// writeCredentials writes a credentials file to a temporary directory and
+// returns its path along with a function that removes it
+func writeCredentials(t *testing.T, contents string) (string, func()) {
+	dir, err := ioutil.TempDir("", "aws-mfa")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "credentials")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func permanentSection(extra string) string {
+	return `[default-permanent]
+aws_access_key_id     = AKIAPERMANENT
+aws_secret_access_key = permanent-secret
+` + extra + "\n"
+}
+
+func temporarySection(expires time.Time) string {
+	return `[default]
+aws_access_key_id     = ASIAEXISTING
+aws_secret_access_key = existing-secret
+aws_session_token     = existing-token
+expires               = ` + expires.Format(time.RFC3339) + "\n"
+}
+
+// issuedSection is a temporary section that records when it was issued
+func issuedSection(issued, expires time.Time) string {
+	return temporarySection(expires) + "issued                = " + issued.Format(time.RFC3339) + "\n"
+}
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials string
+		options     Options
+		input       string
+		stsErr      error
+		stsErrs     []error
+
+		wantErr    bool
+		wantCalls  int
+		wantKey    string
+		wantSerial string
+	}{
+		{
+			name:        "skips unexpired credentials",
+			credentials: permanentSection("mfa_serial = "+testSerial) + temporarySection(time.Now().Add(12*time.Hour)),
+			wantCalls:   0,
+			wantKey:     "ASIAEXISTING",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "refreshes credentials close to expiring",
+			credentials: permanentSection("mfa_serial = "+testSerial) + temporarySection(time.Now().Add(10*time.Minute)),
+			input:       "123456\n",
+			wantCalls:   1,
+			wantKey:     "ASIAFAKEACCESSKEYID",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "refreshes missing credentials",
+			credentials: permanentSection("mfa_serial = " + testSerial),
+			input:       "123456\n",
+			wantCalls:   1,
+			wantKey:     "ASIAFAKEACCESSKEYID",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "force refreshes unexpired credentials",
+			credentials: permanentSection("mfa_serial = "+testSerial) + temporarySection(time.Now().Add(12*time.Hour)),
+			options:     Options{Force: true},
+			input:       "123456\n",
+			wantCalls:   1,
+			wantKey:     "ASIAFAKEACCESSKEYID",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "keeps the temporary section when STS fails",
+			credentials: permanentSection("mfa_serial = "+testSerial) + temporarySection(time.Now().Add(10*time.Minute)),
+			input:       "123456\n",
+			stsErr:      errors.New("AccessDenied"),
+			wantErr:     true,
+			wantCalls:   1,
+			wantKey:     "ASIAEXISTING",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "clears the temporary section when STS fails with --clear-on-failure",
+			credentials: permanentSection("mfa_serial = "+testSerial) + temporarySection(time.Now().Add(10*time.Minute)),
+			options:     Options{ClearOnFailure: true},
+			input:       "123456\n",
+			stsErr:      errors.New("AccessDenied"),
+			wantErr:     true,
+			wantCalls:   1,
+			wantKey:     "",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "prompts again when the token code is rejected",
+			credentials: permanentSection("mfa_serial = "+testSerial) + temporarySection(time.Now().Add(10*time.Minute)),
+			input:       "000000\n123456\n",
+			stsErrs:     []error{invalidTokenErr},
+			wantCalls:   2,
+			wantKey:     "ASIAFAKEACCESSKEYID",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "gives up after --mfa-attempts rejected token codes",
+			credentials: permanentSection("mfa_serial = "+testSerial) + temporarySection(time.Now().Add(10*time.Minute)),
+			options:     Options{MFAAttempts: 2},
+			input:       "000000\n111111\n123456\n",
+			stsErrs:     []error{invalidTokenErr, invalidTokenErr},
+			wantErr:     true,
+			wantCalls:   2,
+			wantKey:     "ASIAEXISTING",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "doesn't prompt again when STS is throttling",
+			credentials: permanentSection("mfa_serial = "+testSerial) + temporarySection(time.Now().Add(10*time.Minute)),
+			input:       "123456\n123456\n",
+			stsErrs:     []error{awserr.New("Throttling", "Rate exceeded", nil)},
+			wantErr:     true,
+			wantCalls:   1,
+			wantKey:     "ASIAEXISTING",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "saves the mfa serial passed as an option",
+			credentials: permanentSection(""),
+			options:     Options{MFASerial: testSerial},
+			input:       "123456\n",
+			wantCalls:   1,
+			wantKey:     "ASIAFAKEACCESSKEYID",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "updates a saved mfa serial",
+			credentials: permanentSection("mfa_serial = arn:aws:iam::123456789012:mfa/old"),
+			options:     Options{MFASerial: testSerial},
+			input:       "123456\n",
+			wantCalls:   1,
+			wantKey:     "ASIAFAKEACCESSKEYID",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "skips credentials with more left than --min-remaining",
+			credentials: permanentSection("mfa_serial = "+testSerial) + temporarySection(time.Now().Add(10*time.Minute)),
+			options:     Options{RefreshBefore: Threshold{Duration: 5 * time.Minute}},
+			wantCalls:   0,
+			wantKey:     "ASIAEXISTING",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "skips credentials with more left than refresh_before",
+			credentials: permanentSection("mfa_serial = "+testSerial+"\nrefresh_before = 5m") + temporarySection(time.Now().Add(10*time.Minute)),
+			wantCalls:   0,
+			wantKey:     "ASIAEXISTING",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "--min-remaining takes precedence over refresh_before",
+			credentials: permanentSection("mfa_serial = "+testSerial+"\nrefresh_before = 5m") + temporarySection(time.Now().Add(10*time.Minute)),
+			options:     Options{RefreshBefore: Threshold{Duration: 20 * time.Minute}},
+			input:       "123456\n",
+			wantCalls:   1,
+			wantKey:     "ASIAFAKEACCESSKEYID",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "skips short lived credentials with more than half their lifetime left",
+			credentials: permanentSection("mfa_serial = "+testSerial) + issuedSection(time.Now().Add(-20*time.Minute), time.Now().Add(40*time.Minute)),
+			wantCalls:   0,
+			wantKey:     "ASIAEXISTING",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "refreshes short lived credentials with less than half their lifetime left",
+			credentials: permanentSection("mfa_serial = "+testSerial) + issuedSection(time.Now().Add(-40*time.Minute), time.Now().Add(20*time.Minute)),
+			input:       "123456\n",
+			wantCalls:   1,
+			wantKey:     "ASIAFAKEACCESSKEYID",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "refreshes with a percentage of the lifetime left",
+			credentials: permanentSection("mfa_serial = "+testSerial+"\nrefresh_before = 80%") + issuedSection(time.Now().Add(-10*time.Hour), time.Now().Add(26*time.Hour)),
+			input:       "123456\n",
+			wantCalls:   1,
+			wantKey:     "ASIAFAKEACCESSKEYID",
+			wantSerial:  testSerial,
+		},
+		{
+			name:        "refreshes without an mfa device",
+			credentials: permanentSection(""),
+			wantCalls:   1,
+			wantKey:     "ASIAFAKEACCESSKEYID",
+			wantSerial:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cleanup := writeCredentials(t, tt.credentials)
+			defer cleanup()
+
+			options := tt.options
+			options.CredentialsFileLocation = path
+			options.Profile = "default"
+			options.ProfileSuffix = "permanent"
+
+			config, err := options.Validate()
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			defer config.Close()
+
+			fake := &fakeSTS{err: tt.stsErr, errs: tt.stsErrs}
+			refresher, err := NewRefresher(config, fake.factory)
+			if err != nil {
+				t.Fatalf("NewRefresher() error = %v", err)
+			}
+			if tt.input != "" {
+				refresher.tokens = TerminalToken{Input: strings.NewReader(tt.input)}
+			}
+
+			err = refresher.Refresh()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Refresh() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if fake.calls() != tt.wantCalls {
+				t.Errorf("STS calls = %d, want %d", fake.calls(), tt.wantCalls)
+			}
+			// each request uses the next code typed in
+			codes := strings.Fields(tt.input)
+			for i, input := range fake.sessionTokenInputs {
+				if tt.wantSerial != "" && (input.SerialNumber == nil || *input.SerialNumber != tt.wantSerial) {
+					t.Errorf("SerialNumber = %v, want %s", input.SerialNumber, tt.wantSerial)
+				}
+				if tt.wantSerial != "" && (input.TokenCode == nil || *input.TokenCode != codes[i]) {
+					t.Errorf("TokenCode = %v, want %s", input.TokenCode, codes[i])
+				}
+			}
+
+			saved, err := ini.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := saved.Section("default").Key(accessKeyIDKey).String(); got != tt.wantKey {
+				t.Errorf("temporary %s = %q, want %q", accessKeyIDKey, got, tt.wantKey)
+			}
+			if got := saved.Section("default-permanent").Key(mfaSerialKey).String(); got != tt.wantSerial {
+				t.Errorf("permanent %s = %q, want %q", mfaSerialKey, got, tt.wantSerial)
+			}
+		})
+	}
+}
+
+func TestNeedsRefreshNow(t *testing.T) {
+	path, cleanup := writeCredentials(t, permanentSection("mfa_serial = "+testSerial)+temporarySection(time.Now().Add(10*time.Minute)))
+	defer cleanup()
+
+	config, err := Options{
+		CredentialsFileLocation: path,
+		Profile:                 "default",
+		ProfileSuffix:           "permanent",
+		RefreshBefore:           Threshold{Duration: 5 * time.Minute},
+	}.Validate()
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	defer config.Close()
+
+	refresher, _ := NewRefresher(config, (&fakeSTS{}).factory)
+	if ok, _ := refresher.NeedsRefresh(); ok {
+		t.Error("NeedsRefresh() = true, want false with 10 minutes left")
+	}
+
+	refresher.now = func() time.Time { return time.Now().Add(6 * time.Minute) }
+	if ok, _ := refresher.NeedsRefresh(); !ok {
+		t.Error("NeedsRefresh() = false, want true 6 minutes later")
+	}
+}
+
+func TestRefreshClearOnFailureError(t *testing.T) {
+	path, cleanup := writeCredentials(t, permanentSection("mfa_serial = "+testSerial)+temporarySection(time.Now().Add(time.Minute)))
+	defer cleanup()
+
+	config, err := Options{
+		CredentialsFileLocation: path,
+		Profile:                 "default",
+		ProfileSuffix:           "permanent",
+		ClearOnFailure:          true,
+		Token:                   "123456",
+	}.Validate()
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	defer config.Close()
+
+	fake := &fakeSTS{err: errors.New("AccessDenied")}
+	refresher, _ := NewRefresher(config, fake.factory)
+
+	// saving fails
+	config.Options.CredentialsFileLocation = filepath.Join(path, "missing", "credentials")
+
+	err = refresher.Refresh()
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied") || !strings.Contains(err.Error(), "couldn't be cleared") {
+		t.Errorf("Refresh() error = %v, want the STS error and the clear error", err)
+	}
+}
+
+func TestRefreshWaitsForAnotherRefresh(t *testing.T) {
+	path, cleanup := writeCredentials(t, permanentSection("mfa_serial = "+testSerial))
+	defer cleanup()
+
+	options := Options{
+		CredentialsFileLocation: path,
+		Profile:                 "default",
+		ProfileSuffix:           "permanent",
+		LockTimeout:             100 * time.Millisecond,
+	}
+
+	config, err := options.Validate()
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	fake := &fakeSTS{}
+	refresher, _ := NewRefresher(config, fake.factory)
+	token := blockingToken{prompted: make(chan struct{}), code: make(chan string)}
+	refresher.tokens = token
+
+	go func() {
+		if err := refresher.Refresh(); err != nil {
+			t.Errorf("Refresh() error = %v", err)
+		}
+		config.Close()
+	}()
+	<-token.prompted
+
+	// another aws-mfa started during the prompt waits past its lock timeout,
+	// then finds the new credentials instead of prompting again
+	waiting := make(chan *Config)
+	go func() {
+		other, err := options.Validate()
+		if err != nil {
+			t.Errorf("Validate() error = %v, want it to wait for the refresh", err)
+		}
+		waiting <- other
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	token.code <- "123456"
+
+	other := <-waiting
+	if other == nil {
+		return
+	}
+	defer other.Close()
+
+	second, _ := NewRefresher(other, fake.factory)
+	if ok, _ := second.NeedsRefresh(); ok {
+		t.Error("NeedsRefresh() = true after waiting for the other refresh")
+	}
+}
+
+func TestRefreshAssumeRole(t *testing.T) {
+	path, cleanup := writeCredentials(t, permanentSection("mfa_serial = "+testSerial+"\nrole_arn = arn:aws:iam::210987654321:role/admin\nexternal_id = external"))
+	defer cleanup()
+
+	config, err := Options{
+		CredentialsFileLocation: path,
+		Profile:                 "default",
+		ProfileSuffix:           "permanent",
+		RoleSessionName:         "test-session",
+	}.Validate()
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	defer config.Close()
+
+	fake := &fakeSTS{}
+	refresher, _ := NewRefresher(config, fake.factory)
+	refresher.tokens = StaticToken("123456")
+
+	if err := refresher.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	if len(fake.assumeRoleInputs) != 1 || len(fake.sessionTokenInputs) != 0 {
+		t.Fatalf("expected a single AssumeRole call, got %d AssumeRole and %d GetSessionToken", len(fake.assumeRoleInputs), len(fake.sessionTokenInputs))
+	}
+
+	input := fake.assumeRoleInputs[0]
+	if got := *input.RoleArn; got != "arn:aws:iam::210987654321:role/admin" {
+		t.Errorf("RoleArn = %s", got)
+	}
+	if got := *input.RoleSessionName; got != "test-session" {
+		t.Errorf("RoleSessionName = %s", got)
+	}
+	if got := *input.ExternalId; got != "external" {
+		t.Errorf("ExternalId = %s", got)
+	}
+	if got := *input.DurationSeconds; got != int64(defaultRoleDuration.Seconds()) {
+		t.Errorf("DurationSeconds = %d, want %d", got, int64(defaultRoleDuration.Seconds()))
+	}
+	if got := *input.SerialNumber; got != testSerial {
+		t.Errorf("SerialNumber = %s", got)
+	}
+}
+
+func TestRefreshTokenError(t *testing.T) {
+	path, cleanup := writeCredentials(t, permanentSection("mfa_serial = "+testSerial)+temporarySection(time.Now().Add(5*time.Minute)))
+	defer cleanup()
+
+	config, err := Options{
+		CredentialsFileLocation: path,
+		Profile:                 "default",
+		ProfileSuffix:           "permanent",
+		Force:                   true,
+	}.Validate()
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	defer config.Close()
+
+	fake := &fakeSTS{}
+	refresher, _ := NewRefresher(config, fake.factory)
+	refresher.tokens = EnvToken("AWS_MFA_TEST_UNSET_TOKEN")
+
+	if err := refresher.Refresh(); err == nil {
+		t.Error("Refresh() should fail when there's no token code")
+	}
+	if _, err := refresher.SessionToken(minDuration); err == nil {
+		t.Error("SessionToken() should fail when there's no token code")
+	}
+	if fake.calls() != 0 {
+		t.Errorf("STS calls = %d, want 0", fake.calls())
+	}
+
+	saved, err := ini.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := saved.Section("default").Key(accessKeyIDKey).String(); got != "ASIAEXISTING" {
+		t.Errorf("%s = %q, the existing credentials should be kept", accessKeyIDKey, got)
+	}
+}
+
+func TestRefreshVerify(t *testing.T) {
+	other := &sts.GetCallerIdentityOutput{
+		Account: aws.String("210987654321"),
+		Arn:     aws.String("arn:aws:sts::210987654321:assumed-role/other/aws-mfa"),
+	}
+
+	tests := []struct {
+		name         string
+		expected     string
+		identity     *sts.GetCallerIdentityOutput
+		verifyErr    error
+		skipVerify   bool
+		wantErr      bool
+		wantMismatch bool
+		wantKey      string
+		wantCalls    int
+	}{
+		{name: "no expected account", wantKey: "ASIAFAKEACCESSKEYID", wantCalls: 1},
+		{name: "expected account", expected: "123456789012", wantKey: "ASIAFAKEACCESSKEYID", wantCalls: 1},
+		{name: "other account", expected: "123456789012", identity: other, wantErr: true, wantMismatch: true, wantKey: "ASIAEXISTING", wantCalls: 1},
+		{name: "verify fails", verifyErr: awserr.New("InvalidClientTokenId", "The security token included in the request is invalid.", nil), wantErr: true, wantKey: "ASIAEXISTING", wantCalls: 1},
+		{name: "skip verify", expected: "123456789012", identity: other, skipVerify: true, wantKey: "ASIAFAKEACCESSKEYID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extra := "mfa_serial = " + testSerial
+			if tt.expected != "" {
+				extra += "\nexpected_account_id = " + tt.expected
+			}
+			expires := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+			path, cleanup := writeCredentials(t, permanentSection(extra)+temporarySection(expires))
+			defer cleanup()
+
+			config, err := Options{
+				CredentialsFileLocation: path,
+				Profile:                 "default",
+				ProfileSuffix:           "permanent",
+				SkipVerify:              tt.skipVerify,
+			}.Validate()
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			defer config.Close()
+
+			fake := &fakeSTS{identity: tt.identity}
+			if tt.verifyErr != nil {
+				// the session token request succeeds, the check doesn't
+				fake.errs = []error{nil, tt.verifyErr}
+			}
+			refresher, _ := NewRefresher(config, fake.factory)
+			refresher.tokens = StaticToken("123456")
+
+			err = refresher.Refresh()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Refresh() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, ok := err.(*AccountMismatchError); ok != tt.wantMismatch {
+				t.Errorf("Refresh() error = %v, want an AccountMismatchError: %v", err, tt.wantMismatch)
+			}
+			if got := len(fake.callerIdentityInputs); got != tt.wantCalls {
+				t.Errorf("GetCallerIdentity calls = %d, want %d", got, tt.wantCalls)
+			}
+
+			saved, err := ini.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			section := saved.Section("default")
+			if got := section.Key(accessKeyIDKey).String(); got != tt.wantKey {
+				t.Errorf("%s = %q, want %q", accessKeyIDKey, got, tt.wantKey)
+			}
+
+			switch {
+			case tt.wantErr:
+				if got := section.Key(expiresKey).String(); got != expires.Format(time.RFC3339) {
+					t.Errorf("%s = %q, want the previous %q", expiresKey, got, expires.Format(time.RFC3339))
+				}
+				for _, key := range []string{issuedKey, accountIDKey, callerARNKey} {
+					if section.HasKey(key) {
+						t.Errorf("%s = %q, want it removed with the new credentials", key, section.Key(key).String())
+					}
+				}
+			case tt.skipVerify:
+				if section.HasKey(accountIDKey) || section.HasKey(callerARNKey) {
+					t.Errorf("%s and %s should only be set when verifying", accountIDKey, callerARNKey)
+				}
+			default:
+				if got := section.Key(accountIDKey).String(); got != "123456789012" {
+					t.Errorf("%s = %q, want 123456789012", accountIDKey, got)
+				}
+				if got := section.Key(callerARNKey).String(); got != "arn:aws:sts::123456789012:assumed-role/test/aws-mfa" {
+					t.Errorf("%s = %q", callerARNKey, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshOutputFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		extra    string
+		option   bool
+		existing string
+	}{
+		{name: "--output-file", option: true},
+		{name: "temp_credentials_file", extra: "temp_credentials_file = %s"},
+		{name: "existing file", option: true, existing: "# session credentials\n[other]\naws_access_key_id = ASIAOTHER\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cleanup := writeCredentials(t, "")
+			defer cleanup()
+			output := path + ".session"
+
+			// without created or mfa_serial, a refresh would normally add them
+			extra := ""
+			if tt.extra != "" {
+				extra = fmt.Sprintf(tt.extra, output)
+			}
+			credentials := permanentSection(extra)
+			if err := ioutil.WriteFile(path, []byte(credentials), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if tt.existing != "" {
+				if err := ioutil.WriteFile(output, []byte(tt.existing), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			options := Options{
+				CredentialsFileLocation: path,
+				Profile:                 "default",
+				ProfileSuffix:           "permanent",
+				MFASerial:               testSerial,
+			}
+			if tt.option {
+				options.OutputFileLocation = output
+			}
+			config, err := options.Validate()
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			defer config.Close()
+
+			fake := &fakeSTS{}
+			refresher, _ := NewRefresher(config, fake.factory)
+			refresher.tokens = StaticToken("123456")
+
+			if err := refresher.Refresh(); err != nil {
+				t.Fatalf("Refresh() error = %v", err)
+			}
+
+			if got, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(got, []byte(credentials)) {
+				t.Errorf("the credentials file changed:\n%s", got)
+			}
+
+			data, err := ioutil.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(data), tt.existing) {
+				t.Errorf("the output file lost its contents:\n%s", data)
+			}
+			saved, err := ini.Load(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := saved.Section("default").Key(accessKeyIDKey).String(); got != "ASIAFAKEACCESSKEYID" {
+				t.Errorf("%s = %q, want ASIAFAKEACCESSKEYID", accessKeyIDKey, got)
+			}
+
+			if err := refresher.Clear(false); err != nil {
+				t.Fatalf("Clear() error = %v", err)
+			}
+			saved, err = ini.Load(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.Section("default").HasKey(accessKeyIDKey) {
+				t.Error("Clear() left the temporary credentials in the output file")
+			}
+			if got, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(got, []byte(credentials)) {
+				t.Errorf("Clear() changed the credentials file:\n%s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package mfa
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/sirupsen/logrus"
+)
+
+// A new access key can take a few seconds to work everywhere, so it's checked
+// a few times before giving up on it
+var (
+	rotateVerifyAttempts = 5
+	rotateVerifyInterval = 2 * time.Second
+)
+
+// maxAccessKeys is how many access keys IAM allows a user to have
+const maxAccessKeys = 2
+
+// rotatedKeys are the keys in the source section changed by a rotation,
+// besides the access key which may be kept elsewhere
+var rotatedKeys = []string{createdKey, rotatedKey}
+
+// Rotate replaces the access key of the source section with a new one. The
+// IAM requests are made with an MFA session. The new key is saved and checked
+// with GetCallerIdentity before the old one is deactivated and deleted, if
+// anything fails before then the old key is put back and the new one deleted.
+// When the new key was created and rotated is recorded with it.
+// With dryRun set, only the checks that don't change anything are made.
+func (r Refresher) Rotate(newIAM IAMFactory, dryRun bool) error {
+	source := r.Config.Source
+	log := r.log.WithField("profile", source.Profile)
+
+	if source.Section.HasKey(sessionTokenKey) {
+		return fmt.Errorf("the [%s] section has temporary credentials, only access keys can be rotated", source.Profile)
+	}
+	oldID, oldSecret, err := r.Config.AccessKey()
+	if err != nil {
+		return err
+	}
+	previous := sectionValues(source.Section, rotatedKeys)
+
+	session, err := r.SessionToken(minDuration)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to get an MFA session to rotate the access key with")
+		return err
+	}
+
+	awsConfig, err := r.awsConfig(session)
+	if err != nil {
+		return err
+	}
+	svc := newIAM(awsConfig)
+
+	listed, err := svc.ListAccessKeys(&iam.ListAccessKeysInput{})
+	if err != nil {
+		log.WithError(err).Errorln("Failed to list your access keys")
+		return err
+	}
+	keys := listed.AccessKeyMetadata
+	if !hasAccessKey(keys, oldID) {
+		return fmt.Errorf("the access key %s in [%s] doesn't belong to the user of the MFA session", oldID, source.Profile)
+	}
+	if len(keys) >= maxAccessKeys {
+		return fmt.Errorf("you already have %d access keys, delete the one that isn't %s before rotating", len(keys), oldID)
+	}
+
+	if dryRun {
+		log.Infof("Dry run, would create a new access key, save it to [%s], then deactivate and delete %s", source.Profile, oldID)
+		return nil
+	}
+
+	created, err := svc.CreateAccessKey(&iam.CreateAccessKeyInput{})
+	if err != nil {
+		log.WithError(err).Errorln("Failed to create a new access key")
+		return err
+	}
+	key := created.AccessKey
+	newID := aws.StringValue(key.AccessKeyId)
+	log.WithField("access_key_id", newID).Infoln("Created a new access key")
+
+	rollback := func(cause error) error {
+		log.WithError(cause).Errorln("Failed to switch to the new access key, rolling back")
+
+		restoreSection(source.Section, rotatedKeys, previous)
+		if err := r.Config.SetAccessKey(oldID, oldSecret); err != nil {
+			log.WithError(err).Errorf("Failed to put %s back in [%s], it's still active", oldID, source.Profile)
+		} else if err := r.Config.Save(); err != nil {
+			log.WithError(err).Errorf("Failed to put %s back in [%s], it's still active", oldID, source.Profile)
+		}
+
+		if _, err := svc.DeleteAccessKey(&iam.DeleteAccessKeyInput{AccessKeyId: aws.String(newID)}); err != nil {
+			log.WithError(err).Errorf("Failed to delete the new access key %s, delete it yourself", newID)
+		}
+		return cause
+	}
+
+	if err := r.Config.SetAccessKey(newID, aws.StringValue(key.SecretAccessKey)); err != nil {
+		return rollback(err)
+	}
+	createDate := r.now()
+	if key.CreateDate != nil {
+		createDate = *key.CreateDate
+	}
+	source.Section.Key(createdKey).SetValue(createDate.UTC().Format(time.RFC3339))
+	source.Section.Key(rotatedKey).SetValue(r.now().UTC().Format(time.RFC3339))
+	if err := r.Config.Save(); err != nil {
+		return rollback(err)
+	}
+
+	if err := r.verifyAccessKey(key); err != nil {
+		return rollback(err)
+	}
+
+	deactivate := &iam.UpdateAccessKeyInput{AccessKeyId: aws.String(oldID), Status: iam.StatusTypeInactive}
+	if _, err := svc.UpdateAccessKey(deactivate); err != nil {
+		log.WithError(err).Errorf("Failed to deactivate the old access key %s, the new one is saved and works", oldID)
+		return err
+	}
+	if _, err := svc.DeleteAccessKey(&iam.DeleteAccessKeyInput{AccessKeyId: aws.String(oldID)}); err != nil {
+		log.WithError(err).Errorf("Failed to delete the old access key %s, it's inactive and the new one is saved", oldID)
+		return err
+	}
+
+	log.WithFields(logrus.Fields{"old": oldID, "new": newID}).Infoln("Rotated the access key")
+	return nil
+}
+
+// verifyAccessKey checks a new access key works, waiting for it to be usable
+func (r Refresher) verifyAccessKey(key *iam.AccessKey) error {
+	credentials := &sts.Credentials{
+		AccessKeyId:     key.AccessKeyId,
+		SecretAccessKey: key.SecretAccessKey,
+	}
+
+	var err error
+	for attempt := 1; attempt <= rotateVerifyAttempts; attempt++ {
+		var identity *sts.GetCallerIdentityOutput
+		if identity, err = r.CallerIdentity(credentials); err == nil {
+			r.log.WithField("arn", aws.StringValue(identity.Arn)).Infoln("Verified the new access key")
+			return nil
+		}
+
+		if ClassifyError(err) != InvalidCredentialsError || attempt == rotateVerifyAttempts {
+			break
+		}
+		r.log.Debugf("The new access key isn't usable yet, checking again in %s", rotateVerifyInterval)
+		time.Sleep(rotateVerifyInterval)
+	}
+
+	return err
+}
+
+// hasAccessKey returns true if one of keys has the ID
+func hasAccessKey(keys []iam.AccessKeyMetadata, id string) bool {
+	for _, key := range keys {
+		if aws.StringValue(key.AccessKeyId) == id {
+			return true
+		}
+	}
+	return false
+}
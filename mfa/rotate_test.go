@@ -0,0 +1,290 @@
+package mfa
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/go-ini/ini"
+)
+
+// fakeIAM is a local IAM endpoint that keeps the access keys and MFA devices
+// of one user
+type fakeIAM struct {
+	keys    map[string]iam.StatusType
+	created int
+	devices []string
+
+	// fail makes the action with this name return an error
+	fail string
+
+	actions []string
+
+	// signedWith is the access key ID the last request was signed with
+	signedWith string
+}
+
+// Access key IDs used by the rotation tests, IAM requires them to be at least
+// 16 characters
+const (
+	oldKeyID   = "AKIAPERMANENT0000001"
+	otherKeyID = "AKIAOTHER00000000001"
+	newKeyID   = "AKIANEW0000000000001"
+)
+
+// credentialPattern finds the access key ID in a signed request
+var credentialPattern = regexp.MustCompile(`Credential=(\w+)/`)
+
+func (f *fakeIAM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	action := r.Form.Get("Action")
+	f.actions = append(f.actions, action)
+	if match := credentialPattern.FindStringSubmatch(r.Header.Get("Authorization")); match != nil {
+		f.signedWith = match[1]
+	}
+
+	if action == f.fail {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>LimitExceeded</Code><Message>%s failed</Message></Error><RequestId>test</RequestId></ErrorResponse>`, action)
+		return
+	}
+
+	result := ""
+	switch action {
+	case "ListAccessKeys":
+		for id, status := range f.keys {
+			result += fmt.Sprintf(`<member><AccessKeyId>%s</AccessKeyId><Status>%s</Status><UserName>test</UserName><CreateDate>2030-01-01T00:00:00Z</CreateDate></member>`, id, status)
+		}
+		result = "<AccessKeyMetadata>" + result + "</AccessKeyMetadata>"
+	case "CreateAccessKey":
+		f.created++
+		id := fmt.Sprintf("AKIANEW%013d", f.created)
+		f.keys[id] = iam.StatusTypeActive
+		result = fmt.Sprintf(`<AccessKey><AccessKeyId>%s</AccessKeyId><SecretAccessKey>new-secret</SecretAccessKey><Status>Active</Status><UserName>test</UserName><CreateDate>2030-01-01T00:00:00Z</CreateDate></AccessKey>`, id)
+	case "UpdateAccessKey":
+		f.keys[r.Form.Get("AccessKeyId")] = iam.StatusType(r.Form.Get("Status"))
+	case "DeleteAccessKey":
+		delete(f.keys, r.Form.Get("AccessKeyId"))
+	case "ListMFADevices":
+		for _, serial := range f.devices {
+			result += fmt.Sprintf(`<member><SerialNumber>%s</SerialNumber><UserName>test</UserName><EnableDate>2030-01-01T00:00:00Z</EnableDate></member>`, serial)
+		}
+		result = "<MFADevices>" + result + "</MFADevices>"
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintf(w, `<%[1]sResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/"><%[1]sResult>%[2]s</%[1]sResult><ResponseMetadata><RequestId>test</RequestId></ResponseMetadata></%[1]sResponse>`, action, result)
+}
+
+func TestRotate(t *testing.T) {
+	defer func(attempts int, interval time.Duration) {
+		rotateVerifyAttempts, rotateVerifyInterval = attempts, interval
+	}(rotateVerifyAttempts, rotateVerifyInterval)
+	rotateVerifyAttempts, rotateVerifyInterval = 2, 0
+
+	invalidKey := awserr.New("InvalidClientTokenId", "The security token included in the request is invalid.", nil)
+
+	tests := []struct {
+		name      string
+		keys      map[string]iam.StatusType
+		fail      string
+		stsErrs   []error
+		dryRun    bool
+		wantErr   bool
+		wantKey   string
+		wantKeys  []string
+		wantCalls []string
+	}{
+		{
+			name:      "rotate",
+			keys:      map[string]iam.StatusType{oldKeyID: iam.StatusTypeActive},
+			wantKey:   newKeyID,
+			wantKeys:  []string{newKeyID},
+			wantCalls: []string{"ListAccessKeys", "CreateAccessKey", "UpdateAccessKey", "DeleteAccessKey"},
+		},
+		{
+			name:      "new key takes a while to work",
+			keys:      map[string]iam.StatusType{oldKeyID: iam.StatusTypeActive},
+			stsErrs:   []error{nil, invalidKey},
+			wantKey:   newKeyID,
+			wantKeys:  []string{newKeyID},
+			wantCalls: []string{"ListAccessKeys", "CreateAccessKey", "UpdateAccessKey", "DeleteAccessKey"},
+		},
+		{
+			name:      "dry run",
+			keys:      map[string]iam.StatusType{oldKeyID: iam.StatusTypeActive},
+			dryRun:    true,
+			wantKey:   oldKeyID,
+			wantKeys:  []string{oldKeyID},
+			wantCalls: []string{"ListAccessKeys"},
+		},
+		{
+			name:      "new key doesn't work",
+			keys:      map[string]iam.StatusType{oldKeyID: iam.StatusTypeActive},
+			stsErrs:   []error{nil, invalidKey, invalidKey},
+			wantErr:   true,
+			wantKey:   oldKeyID,
+			wantKeys:  []string{oldKeyID},
+			wantCalls: []string{"ListAccessKeys", "CreateAccessKey", "DeleteAccessKey"},
+		},
+		{
+			name:      "too many keys",
+			keys:      map[string]iam.StatusType{oldKeyID: iam.StatusTypeActive, otherKeyID: iam.StatusTypeInactive},
+			wantErr:   true,
+			wantKey:   oldKeyID,
+			wantKeys:  []string{otherKeyID, oldKeyID},
+			wantCalls: []string{"ListAccessKeys"},
+		},
+		{
+			name:      "key of another user",
+			keys:      map[string]iam.StatusType{otherKeyID: iam.StatusTypeActive},
+			wantErr:   true,
+			wantKey:   oldKeyID,
+			wantKeys:  []string{otherKeyID},
+			wantCalls: []string{"ListAccessKeys"},
+		},
+		{
+			name:      "create fails",
+			keys:      map[string]iam.StatusType{oldKeyID: iam.StatusTypeActive},
+			fail:      "CreateAccessKey",
+			wantErr:   true,
+			wantKey:   oldKeyID,
+			wantKeys:  []string{oldKeyID},
+			wantCalls: []string{"ListAccessKeys", "CreateAccessKey"},
+		},
+		{
+			name:      "deactivate fails",
+			keys:      map[string]iam.StatusType{oldKeyID: iam.StatusTypeActive},
+			fail:      "UpdateAccessKey",
+			wantErr:   true,
+			wantKey:   newKeyID,
+			wantKeys:  []string{newKeyID, oldKeyID},
+			wantCalls: []string{"ListAccessKeys", "CreateAccessKey", "UpdateAccessKey"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cleanup := writeCredentials(t, `[default-permanent]
+aws_access_key_id     = `+oldKeyID+`
+aws_secret_access_key = permanent-secret
+mfa_serial            = `+testSerial+"\n")
+			defer cleanup()
+
+			config, err := Options{
+				CredentialsFileLocation: path,
+				Profile:                 "default",
+				ProfileSuffix:           "permanent",
+			}.Validate()
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			defer config.Close()
+
+			endpoint := &fakeIAM{keys: tt.keys, fail: tt.fail}
+			server := httptest.NewServer(endpoint)
+			defer server.Close()
+
+			newIAM := func(cfg aws.Config) IAM {
+				cfg.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+				cfg.Retryer = aws.DefaultRetryer{NumMaxRetries: 0}
+				return NewIAM(cfg)
+			}
+
+			fake := &fakeSTS{errs: tt.stsErrs}
+			refresher, _ := NewRefresher(config, fake.factory)
+			refresher.tokens = StaticToken("123456")
+
+			err = refresher.Rotate(newIAM, tt.dryRun)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Rotate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := strings.Join(endpoint.actions, ","); got != strings.Join(tt.wantCalls, ",") {
+				t.Errorf("IAM actions = %s, want %s", got, strings.Join(tt.wantCalls, ","))
+			}
+
+			var keys []string
+			for id := range endpoint.keys {
+				keys = append(keys, id)
+			}
+			sort.Strings(keys)
+			if got, want := strings.Join(keys, ","), strings.Join(tt.wantKeys, ","); got != want {
+				t.Errorf("access keys = %s, want %s", got, want)
+			}
+
+			// the MFA session is used for IAM, the new key is checked with
+			// GetCallerIdentity
+			if len(fake.sessionTokenInputs) != 1 || aws.StringValue(fake.sessionTokenInputs[0].TokenCode) != "123456" {
+				t.Errorf("expected a single GetSessionToken call with the token code, got %d", len(fake.sessionTokenInputs))
+			}
+
+			saved, err := ini.Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			section := saved.Section("default-permanent")
+			if got := section.Key(accessKeyIDKey).String(); got != tt.wantKey {
+				t.Errorf("%s = %q, want %q", accessKeyIDKey, got, tt.wantKey)
+			}
+			wantSecret := "permanent-secret"
+			if tt.wantKey != oldKeyID {
+				wantSecret = "new-secret"
+			}
+			if got := section.Key(secretAccessKey).String(); got != wantSecret {
+				t.Errorf("%s = %q, want %q", secretAccessKey, got, wantSecret)
+			}
+
+			wantCreated := ""
+			if tt.wantKey != oldKeyID {
+				wantCreated = "2030-01-01T00:00:00Z"
+			}
+			if got := section.Key(createdKey).String(); got != wantCreated {
+				t.Errorf("%s = %q, want %q", createdKey, got, wantCreated)
+			}
+			if got := section.HasKey(rotatedKey); got != (wantCreated != "") {
+				t.Errorf("%s set = %v, want %v", rotatedKey, got, wantCreated != "")
+			}
+		})
+	}
+}
+
+func TestRotateTemporarySource(t *testing.T) {
+	path, cleanup := writeCredentials(t, permanentSection("aws_session_token = token\nmfa_serial = "+testSerial))
+	defer cleanup()
+
+	config, err := Options{
+		CredentialsFileLocation: path,
+		Profile:                 "default",
+		ProfileSuffix:           "permanent",
+	}.Validate()
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	defer config.Close()
+
+	fake := &fakeSTS{}
+	refresher, _ := NewRefresher(config, fake.factory)
+	refresher.tokens = StaticToken("123456")
+
+	newIAM := func(cfg aws.Config) IAM {
+		t.Fatal("IAM shouldn't be used")
+		return nil
+	}
+	if err := refresher.Rotate(newIAM, false); err == nil {
+		t.Fatal("Rotate() should fail for temporary credentials")
+	}
+	if fake.calls() != 0 {
+		t.Errorf("STS calls = %d, want 0", fake.calls())
+	}
+}
@@ -0,0 +1,252 @@
+package mfa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Output formats of the status
+const (
+	TableOutput = "table"
+	JSONOutput  = "json"
+	YAMLOutput  = "yaml"
+)
+
+// OutputFormats lists the supported output formats.
+func OutputFormats() []string {
+	return []string{TableOutput, JSONOutput, YAMLOutput}
+}
+
+// Status describes a profile and its temporary credentials.
+type Status struct {
+	Profile    string     `json:"profile"`
+	MFASerial  string     `json:"mfa_serial,omitempty"`
+	AccountID  string     `json:"account_id,omitempty"`
+	Expires    *time.Time `json:"expires,omitempty"`
+	Remaining  string     `json:"remaining,omitempty"`
+	RefreshDue bool       `json:"refresh_due"`
+
+	// CallerARN is only set when verifying, and Error when verifying fails
+	// or the profile couldn't be loaded
+	CallerARN string `json:"caller_arn,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// FailedStatus is the status of a profile that couldn't be loaded.
+func FailedStatus(profile string, err error) Status {
+	return Status{Profile: profile, Error: err.Error()}
+}
+
+// arnAccount returns the account ID of an ARN
+func arnAccount(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[4]
+}
+
+// Status reads the status of the temporary credentials. If verify is set the
+// credentials are checked with GetCallerIdentity, so sessions that were
+// revoked show up.
+func (r Refresher) Status(verify bool) Status {
+	due, expires := r.NeedsRefresh()
+
+	status := Status{
+		Profile:    r.Config.Options.Profile,
+		MFASerial:  r.Config.Options.MFASerial,
+		RefreshDue: due,
+	}
+
+	// prefer the account the credentials were verified to belong to, then
+	// the account of the role or the MFA device
+	if r.Config.Temporary.Section.HasKey(accountIDKey) {
+		status.AccountID = r.Config.Temporary.Section.Key(accountIDKey).String()
+	}
+	for _, arn := range []string{r.Config.Options.RoleARN, r.Config.Options.MFASerial} {
+		if status.AccountID == "" {
+			status.AccountID = arnAccount(arn)
+		}
+	}
+
+	credentials, err := r.temporaryCredentials()
+	if err != nil {
+		status.RefreshDue = true
+		if verify {
+			status.Error = err.Error()
+		}
+		return status
+	}
+
+	status.Expires = &expires
+	if remaining := time.Until(expires); remaining > 0 {
+		status.Remaining = remaining.Round(time.Second).String()
+	} else {
+		status.Remaining = "expired"
+	}
+
+	if verify {
+		identity, err := r.CallerIdentity(credentials)
+		if err != nil {
+			status.Error = err.Error()
+			return status
+		}
+		status.AccountID = aws.StringValue(identity.Account)
+		status.CallerARN = aws.StringValue(identity.Arn)
+	}
+
+	return status
+}
+
+// temporaryCredentials reads the temporary section in the form STS returns
+func (r Refresher) temporaryCredentials() (*sts.Credentials, error) {
+	credentials, expires, err := r.Credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sts.Credentials{
+		AccessKeyId:     aws.String(credentials.AccessKeyID),
+		SecretAccessKey: aws.String(credentials.SecretAccessKey),
+		SessionToken:    aws.String(credentials.SessionToken),
+		Expiration:      aws.Time(expires),
+	}, nil
+}
+
+// CallerIdentity calls GetCallerIdentity with credentials, to check they work
+// and find out who they belong to.
+func (r Refresher) CallerIdentity(credentials *sts.Credentials) (*sts.GetCallerIdentityOutput, error) {
+	svc, err := r.client(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		r.log.WithError(err).WithField("profile", r.Config.Options.Profile).Debugln("Failed to get the caller identity")
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// fields returns the name and value of each field to print, in order
+func (s Status) fields(verify bool) [][2]string {
+	expires := ""
+	if s.Expires != nil {
+		expires = s.Expires.Format(time.RFC3339)
+	}
+
+	fields := [][2]string{
+		{"profile", s.Profile},
+		{"mfa_serial", s.MFASerial},
+		{"account_id", s.AccountID},
+		{"expires", expires},
+		{"remaining", s.Remaining},
+		{"refresh_due", strconv.FormatBool(s.RefreshDue)},
+	}
+	if verify {
+		fields = append(fields, [2]string{"caller_arn", s.CallerARN}, [2]string{"error", s.Error})
+	} else if s.Error != "" {
+		fields = append(fields, [2]string{"error", s.Error})
+	}
+
+	return fields
+}
+
+// WriteStatus writes statuses to w in the given format. The caller ARN is only
+// included when verify is set, and errors when verify is set or a profile
+// couldn't be loaded.
+func WriteStatus(w io.Writer, statuses []Status, format string, verify bool) error {
+	switch format {
+	case TableOutput:
+		return writeStatusTable(w, statuses, verify)
+	case JSONOutput:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(statuses)
+	case YAMLOutput:
+		return writeStatusYAML(w, statuses, verify)
+	default:
+		return fmt.Errorf("unknown output format %q, expected one of: %s", format, strings.Join(OutputFormats(), ", "))
+	}
+}
+
+func writeStatusTable(w io.Writer, statuses []Status, verify bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	failed := false
+	for _, s := range statuses {
+		failed = failed || s.Error != ""
+	}
+
+	headers := []string{"PROFILE", "MFA SERIAL", "ACCOUNT", "EXPIRES IN", "REFRESH DUE"}
+	if verify {
+		headers = append(headers, "CALLER")
+	} else if failed {
+		headers = append(headers, "ERROR")
+	}
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+
+	for _, s := range statuses {
+		row := []string{s.Profile, s.MFASerial, s.AccountID, s.Remaining, "no"}
+		if s.RefreshDue {
+			row[4] = "yes"
+		}
+		if verify {
+			caller := s.CallerARN
+			if s.Error != "" {
+				caller = "error: " + s.Error
+			}
+			row = append(row, caller)
+		} else if failed {
+			row = append(row, s.Error)
+		}
+		for i := range row {
+			if row[i] == "" {
+				row[i] = "-"
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// writeStatusYAML writes statuses as a YAML sequence. Strings are written as
+// JSON strings, which are valid YAML, so nothing needs escaping by hand.
+func writeStatusYAML(w io.Writer, statuses []Status, verify bool) error {
+	if len(statuses) == 0 {
+		_, err := fmt.Fprintln(w, "[]")
+		return err
+	}
+
+	for _, s := range statuses {
+		for i, field := range s.fields(verify) {
+			prefix := "  "
+			if i == 0 {
+				prefix = "- "
+			}
+
+			value := field[1]
+			if field[0] != "refresh_due" {
+				quoted, _ := json.Marshal(value)
+				value = string(quoted)
+			}
+
+			if _, err := fmt.Fprintf(w, "%s%s: %s\n", prefix, field[0], value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package mfa
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+func TestStatus(t *testing.T) {
+	credentials := permanentSection("mfa_serial = "+testSerial) + temporarySection(time.Now().Add(12*time.Hour)) + `
+[admin-permanent]
+role_arn       = arn:aws:iam::210987654321:role/admin
+source_profile = default-permanent
+`
+	path, cleanup := writeCredentials(t, credentials)
+	defer cleanup()
+
+	configs, err := Options{
+		CredentialsFileLocation: path,
+		ProfileSuffix:           "permanent",
+	}.ValidateProfiles(nil)
+	if err != nil {
+		t.Fatalf("ValidateProfiles() error = %v", err)
+	}
+	defer configs[0].Close()
+
+	tests := []struct {
+		name   string
+		config *Config
+		verify bool
+		stsErr error
+
+		want Status
+	}{
+		{
+			name:   "unexpired credentials",
+			config: configs[0],
+			want:   Status{Profile: "default", MFASerial: testSerial, AccountID: "123456789012", Remaining: "12h0m0s"},
+		},
+		{
+			name:   "missing credentials",
+			config: configs[1],
+			want:   Status{Profile: "admin", MFASerial: testSerial, AccountID: "210987654321", RefreshDue: true},
+		},
+		{
+			name:   "verified credentials",
+			config: configs[0],
+			verify: true,
+			want: Status{Profile: "default", MFASerial: testSerial, AccountID: "123456789012", Remaining: "12h0m0s",
+				CallerARN: "arn:aws:sts::123456789012:assumed-role/test/aws-mfa"},
+		},
+		{
+			name:   "revoked credentials",
+			config: configs[0],
+			verify: true,
+			stsErr: awserr.New("InvalidClientTokenId", "The security token included in the request is invalid.", nil),
+			want: Status{Profile: "default", MFASerial: testSerial, AccountID: "123456789012", Remaining: "12h0m0s",
+				Error: "InvalidClientTokenId: The security token included in the request is invalid."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeSTS{err: tt.stsErr}
+			refresher, err := NewRefresher(tt.config, fake.factory)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := refresher.Status(tt.verify)
+
+			// the remaining time depends on how long the test took
+			if got.Remaining != "" && got.Remaining != "expired" {
+				remaining, err := time.ParseDuration(got.Remaining)
+				if err != nil || remaining < 12*time.Hour-time.Minute {
+					t.Errorf("Remaining = %q", got.Remaining)
+				}
+				got.Remaining = "12h0m0s"
+			}
+			if (got.Expires != nil) != (tt.want.Remaining != "") {
+				t.Errorf("Expires = %v", got.Expires)
+			}
+			got.Expires = nil
+
+			if got != tt.want {
+				t.Errorf("Status() = %+v, want %+v", got, tt.want)
+			}
+			if tt.verify && len(fake.callerIdentityInputs) != 1 {
+				t.Errorf("GetCallerIdentity calls = %d, want 1", len(fake.callerIdentityInputs))
+			}
+		})
+	}
+}
+
+func TestFailedStatus(t *testing.T) {
+	got := FailedStatus("broken", errors.New("the [broken-permanent] section is missing aws_secret_access_key"))
+	want := Status{Profile: "broken", Error: "the [broken-permanent] section is missing aws_secret_access_key"}
+	if got != want {
+		t.Errorf("FailedStatus() = %+v, want %+v", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteStatus(&buf, []Status{got}, YAMLOutput, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `error: "the [broken-permanent] section is missing aws_secret_access_key"`) {
+		t.Errorf("WriteStatus(yaml) doesn't include the error:\n%s", buf.String())
+	}
+}
+
+func TestWriteStatus(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	statuses := []Status{
+		{Profile: "default", MFASerial: testSerial, AccountID: "123456789012", Expires: &expires, Remaining: "5h0m0s"},
+		{Profile: "admin", AccountID: "210987654321", RefreshDue: true, Error: `no "credentials"`},
+	}
+
+	tests := []struct {
+		format string
+		verify bool
+		want   string
+	}{
+		{
+			format: TableOutput,
+			want: `PROFILE  MFA SERIAL                          ACCOUNT       EXPIRES IN  REFRESH DUE  ERROR
+default  arn:aws:iam::123456789012:mfa/test  123456789012  5h0m0s      no           -
+admin    -                                   210987654321  -           yes          no "credentials"
+`,
+		},
+		{
+			format: TableOutput,
+			verify: true,
+			want: `PROFILE  MFA SERIAL                          ACCOUNT       EXPIRES IN  REFRESH DUE  CALLER
+default  arn:aws:iam::123456789012:mfa/test  123456789012  5h0m0s      no           -
+admin    -                                   210987654321  -           yes          error: no "credentials"
+`,
+		},
+		{
+			format: JSONOutput,
+			want: `[
+  {
+    "profile": "default",
+    "mfa_serial": "arn:aws:iam::123456789012:mfa/test",
+    "account_id": "123456789012",
+    "expires": "2030-01-02T03:04:05Z",
+    "remaining": "5h0m0s",
+    "refresh_due": false
+  },
+  {
+    "profile": "admin",
+    "account_id": "210987654321",
+    "refresh_due": true,
+    "error": "no \"credentials\""
+  }
+]
+`,
+		},
+		{
+			format: YAMLOutput,
+			verify: true,
+			want: `- profile: "default"
+  mfa_serial: "arn:aws:iam::123456789012:mfa/test"
+  account_id: "123456789012"
+  expires: "2030-01-02T03:04:05Z"
+  remaining: "5h0m0s"
+  refresh_due: false
+  caller_arn: ""
+  error: ""
+- profile: "admin"
+  mfa_serial: ""
+  account_id: "210987654321"
+  expires: ""
+  remaining: ""
+  refresh_due: true
+  caller_arn: ""
+  error: "no \"credentials\""
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteStatus(&buf, statuses, tt.format, tt.verify); err != nil {
+			t.Fatalf("WriteStatus(%s) error = %v", tt.format, err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("WriteStatus(%s, verify %v) =\n%s\nwant\n%s", tt.format, tt.verify, got, tt.want)
+		}
+	}
+
+	// the error column is only there when a profile has an error
+	var buf bytes.Buffer
+	if err := WriteStatus(&buf, statuses[:1], TableOutput, false); err != nil {
+		t.Fatal(err)
+	}
+	want := `PROFILE  MFA SERIAL                          ACCOUNT       EXPIRES IN  REFRESH DUE
+default  arn:aws:iam::123456789012:mfa/test  123456789012  5h0m0s      no
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteStatus(table) =\n%s\nwant\n%s", got, want)
+	}
+
+	if err := WriteStatus(&bytes.Buffer{}, statuses, "xml", false); err == nil {
+		t.Error("WriteStatus(xml) error = nil, want an error")
+	}
+}
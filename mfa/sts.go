@@ -0,0 +1,38 @@
+package mfa
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// STS is the subset of the STS API used by the Refresher.
+type STS interface {
+	GetSessionToken(input *sts.GetSessionTokenInput) (*sts.GetSessionTokenOutput, error)
+	AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error)
+	GetCallerIdentity(input *sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error)
+}
+
+// STSFactory creates the STS client used by the Refresher. It's only called
+// when STS is needed, to refresh or verify credentials.
+type STSFactory func(cfg aws.Config) STS
+
+// NewSTS is the STSFactory that talks to AWS.
+func NewSTS(cfg aws.Config) STS {
+	return stsClient{svc: sts.New(cfg)}
+}
+
+type stsClient struct {
+	svc *sts.STS
+}
+
+func (c stsClient) GetSessionToken(input *sts.GetSessionTokenInput) (*sts.GetSessionTokenOutput, error) {
+	return c.svc.GetSessionTokenRequest(input).Send()
+}
+
+func (c stsClient) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	return c.svc.AssumeRoleRequest(input).Send()
+}
+
+func (c stsClient) GetCallerIdentity(input *sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return c.svc.GetCallerIdentityRequest(input).Send()
+}
@@ -0,0 +1,104 @@
+package mfa
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// fakeSTS records the requests made to it and responds with fixed credentials
+type fakeSTS struct {
+	err error
+
+	// errs are returned by the first requests, before err
+	errs []error
+
+	// expiration is used for the credentials when set, otherwise they
+	// expire after the requested duration
+	expiration time.Time
+
+	// now is used to work out the expiration when set, for tests that move
+	// the clock
+	now func() time.Time
+
+	// identity is returned by GetCallerIdentity
+	identity *sts.GetCallerIdentityOutput
+
+	// accessKeys are the access key IDs clients were created with
+	accessKeys []string
+
+	sessionTokenInputs   []*sts.GetSessionTokenInput
+	assumeRoleInputs     []*sts.AssumeRoleInput
+	callerIdentityInputs []*sts.GetCallerIdentityInput
+}
+
+func (f *fakeSTS) factory(cfg aws.Config) STS {
+	if credentials, err := cfg.Credentials.Retrieve(); err == nil {
+		f.accessKeys = append(f.accessKeys, credentials.AccessKeyID)
+	}
+	return f
+}
+
+func (f *fakeSTS) nextErr() error {
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return err
+	}
+	return f.err
+}
+
+func (f *fakeSTS) calls() int {
+	return len(f.sessionTokenInputs) + len(f.assumeRoleInputs)
+}
+
+func (f *fakeSTS) credentials(duration *int64) *sts.Credentials {
+	now := time.Now()
+	if f.now != nil {
+		now = f.now()
+	}
+
+	expiration := now.Add(time.Duration(aws.Int64Value(duration)) * time.Second).Truncate(time.Second)
+	if !f.expiration.IsZero() {
+		expiration = f.expiration
+	}
+
+	return &sts.Credentials{
+		AccessKeyId:     aws.String("ASIAFAKEACCESSKEYID"),
+		SecretAccessKey: aws.String("fake-secret-access-key"),
+		SessionToken:    aws.String("fake-session-token"),
+		Expiration:      aws.Time(expiration),
+	}
+}
+
+func (f *fakeSTS) GetSessionToken(input *sts.GetSessionTokenInput) (*sts.GetSessionTokenOutput, error) {
+	f.sessionTokenInputs = append(f.sessionTokenInputs, input)
+	if err := f.nextErr(); err != nil {
+		return nil, err
+	}
+	return &sts.GetSessionTokenOutput{Credentials: f.credentials(input.DurationSeconds)}, nil
+}
+
+func (f *fakeSTS) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	f.assumeRoleInputs = append(f.assumeRoleInputs, input)
+	if err := f.nextErr(); err != nil {
+		return nil, err
+	}
+	return &sts.AssumeRoleOutput{Credentials: f.credentials(input.DurationSeconds)}, nil
+}
+
+func (f *fakeSTS) GetCallerIdentity(input *sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	f.callerIdentityInputs = append(f.callerIdentityInputs, input)
+	if err := f.nextErr(); err != nil {
+		return nil, err
+	}
+	if f.identity != nil {
+		return f.identity, nil
+	}
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String("123456789012"),
+		Arn:     aws.String("arn:aws:sts::123456789012:assumed-role/test/aws-mfa"),
+		UserId:  aws.String("AROAFAKE:aws-mfa"),
+	}, nil
+}
@@ -0,0 +1,75 @@
+package mfa
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultThreshold refreshes credentials when less than an hour, or half of
+// their lifetime, remains.
+var DefaultThreshold = Threshold{Duration: time.Hour, Percent: 50}
+
+// Threshold is how much of their lifetime credentials need to have left to
+// not be refreshed, either a duration or a percentage of the lifetime.
+type Threshold struct {
+	Duration time.Duration
+
+	// Percent of the lifetime, it's only used when the issue time is known.
+	// If Duration is set as well, whichever is smaller is used.
+	Percent float64
+}
+
+// ParseThreshold parses a duration such as 30m, or a percentage such as 25%.
+func ParseThreshold(s string) (Threshold, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return Threshold{}, fmt.Errorf("invalid threshold %q, the percentage must be between 0 and 100", s)
+		}
+		return Threshold{Percent: percent}, nil
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil || duration <= 0 {
+		return Threshold{}, fmt.Errorf("invalid threshold %q, expected a duration like 30m or a percentage like 25%%", s)
+	}
+	return Threshold{Duration: duration}, nil
+}
+
+// IsZero returns true if the threshold isn't set
+func (t Threshold) IsZero() bool {
+	return t.Duration == 0 && t.Percent == 0
+}
+
+// Remaining returns how much time credentials issued and expiring at the
+// given times need to have left. If the issue time isn't known, the lifetime
+// isn't either and a percentage falls back to the default duration.
+func (t Threshold) Remaining(issued, expires time.Time) time.Duration {
+	if t.Percent == 0 || issued.IsZero() || !issued.Before(expires) {
+		if t.Duration == 0 {
+			return DefaultThreshold.Duration
+		}
+		return t.Duration
+	}
+
+	remaining := time.Duration(float64(expires.Sub(issued)) * t.Percent / 100)
+	if t.Duration != 0 && t.Duration < remaining {
+		return t.Duration
+	}
+	return remaining
+}
+
+func (t Threshold) String() string {
+	switch {
+	case t.Percent == 0:
+		return t.Duration.String()
+	case t.Duration == 0:
+		return strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"
+	default:
+		return fmt.Sprintf("%s or %s%%", t.Duration, strconv.FormatFloat(t.Percent, 'f', -1, 64))
+	}
+}
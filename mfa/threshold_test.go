@@ -0,0 +1,64 @@
+package mfa
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Threshold
+		wantErr bool
+	}{
+		{input: "30m", want: Threshold{Duration: 30 * time.Minute}},
+		{input: " 2h ", want: Threshold{Duration: 2 * time.Hour}},
+		{input: "25%", want: Threshold{Percent: 25}},
+		{input: "12.5%", want: Threshold{Percent: 12.5}},
+		{input: "0%", wantErr: true},
+		{input: "100%", wantErr: true},
+		{input: "-5m", wantErr: true},
+		{input: "half", wantErr: true},
+		{input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseThreshold(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseThreshold(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseThreshold(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestThresholdRemaining(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		threshold Threshold
+		lifetime  time.Duration
+		want      time.Duration
+	}{
+		{"duration", Threshold{Duration: 10 * time.Minute}, time.Hour, 10 * time.Minute},
+		{"percentage", Threshold{Percent: 25}, 4 * time.Hour, time.Hour},
+		{"percentage without an issue time", Threshold{Percent: 25}, 0, time.Hour},
+		{"default for long sessions", DefaultThreshold, 36 * time.Hour, time.Hour},
+		{"default for short sessions", DefaultThreshold, time.Hour, 30 * time.Minute},
+		{"default without an issue time", DefaultThreshold, 0, time.Hour},
+	}
+
+	for _, tt := range tests {
+		var issued time.Time
+		if tt.lifetime != 0 {
+			issued = expires.Add(-tt.lifetime)
+		}
+
+		if got := tt.threshold.Remaining(issued, expires); got != tt.want {
+			t.Errorf("%s: Remaining() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
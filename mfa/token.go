@@ -0,0 +1,182 @@
+package mfa
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// DefaultMFAAttempts is how many times a token code is asked for when the
+// ones typed in are rejected
+const DefaultMFAAttempts = 3
+
+// TokenEnvVar is the environment variable an MFA token code is read from.
+const TokenEnvVar = "AWS_MFA_TOKEN"
+
+// errNoInput is returned when an empty line is read from the terminal
+var errNoInput = errors.New("no input given")
+
+// TokenProvider provides the current token code of an MFA device.
+type TokenProvider interface {
+	Token(serial string) (string, error)
+}
+
+// NewTokenProvider picks the token provider for a config. In order of
+// precedence that's the --token option, the AWS_MFA_TOKEN environment
+// variable, a TOTP secret, the mfa_process setting and finally prompting on
+// the terminal.
+func NewTokenProvider(c *Config) (TokenProvider, error) {
+	switch {
+	case c.Options.Token != "":
+		return StaticToken(c.Options.Token), nil
+	case os.Getenv(TokenEnvVar) != "":
+		return EnvToken(TokenEnvVar), nil
+	case hasTOTPSecret(c.Settings):
+		return NewTOTPToken(c.Settings)
+	case c.Settings.String(mfaProcessKey) != "":
+		return ProcessToken(c.Settings.String(mfaProcessKey)), nil
+	default:
+		return TerminalToken{}, nil
+	}
+}
+
+// StaticToken is a token code that was given up front.
+type StaticToken string
+
+func (t StaticToken) Token(serial string) (string, error) {
+	return string(t), nil
+}
+
+// EnvToken reads the token code from the named environment variable.
+type EnvToken string
+
+func (t EnvToken) Token(serial string) (string, error) {
+	token := strings.TrimSpace(os.Getenv(string(t)))
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", string(t))
+	}
+	return token, nil
+}
+
+// ProcessToken runs a command with the shell and uses its output as the token
+// code, e.g. `ykman oath accounts code -s aws`.
+type ProcessToken string
+
+func (t ProcessToken) Token(serial string) (string, error) {
+	logger := log.WithField("prefix", "token")
+
+	cmd := shellCommand(string(t))
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "AWS_MFA_SERIAL="+serial)
+
+	logger.WithField("command", string(t)).Debugln("Running mfa_process")
+
+	output, err := cmd.Output()
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to run mfa_process")
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(output))
+	if token == "" {
+		return "", fmt.Errorf("mfa_process didn't output a token")
+	}
+	return token, nil
+}
+
+// TerminalToken prompts for the token code. It reads from the terminal
+// directly so it still works when stdin is piped, falling back to Input or
+// stdin when there's no terminal.
+type TerminalToken struct {
+	Input io.Reader
+}
+
+func (t TerminalToken) Token(serial string) (string, error) {
+	log.WithField("prefix", "token").Printf("Enter the MFA token code for device %s", serial)
+	return t.readLine()
+}
+
+func (t TerminalToken) readLine() (string, error) {
+	input := t.Input
+	if input == nil {
+		if tty, err := openTerminal(); err == nil {
+			defer tty.Close()
+			input = tty
+		} else {
+			input = os.Stdin
+		}
+	}
+
+	// read a byte at a time rather than buffering, so the next prompt gets
+	// the next line
+	var b strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := input.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			b.WriteByte(buf[0])
+		}
+		if err == io.EOF && b.Len() > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	line := strings.TrimSpace(b.String())
+	if line == "" {
+		return "", errNoInput
+	}
+	return line, nil
+}
+
+// readSecret reads a line without echoing it when there's a terminal,
+// otherwise it's read from stdin like any other line
+func readSecret() (string, error) {
+	tty, err := openTerminal()
+	if err != nil || !terminal.IsTerminal(int(tty.Fd())) {
+		if tty != nil {
+			tty.Close()
+		}
+		return TerminalToken{}.readLine()
+	}
+	defer tty.Close()
+
+	secret, err := terminal.ReadPassword(int(tty.Fd()))
+	// the newline typed in isn't echoed either
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	line := strings.TrimSpace(string(secret))
+	if line == "" {
+		return "", errNoInput
+	}
+	return line, nil
+}
+
+// shellCommand runs command with the shell
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+func openTerminal() (*os.File, error) {
+	if runtime.GOOS == "windows" {
+		return os.Open("CONIN$")
+	}
+	return os.Open("/dev/tty")
+}
@@ -0,0 +1,48 @@
+package mfa
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTokenProviders(t *testing.T) {
+	os.Setenv("AWS_MFA_TEST_TOKEN", " 654321\n")
+	defer os.Unsetenv("AWS_MFA_TEST_TOKEN")
+
+	type test struct {
+		name     string
+		provider TokenProvider
+		want     string
+		wantErr  bool
+	}
+
+	tests := []test{
+		{"static", StaticToken("123456"), "123456", false},
+		{"env", EnvToken("AWS_MFA_TEST_TOKEN"), "654321", false},
+		{"empty env", EnvToken("AWS_MFA_TEST_UNSET"), "", true},
+		{"terminal", TerminalToken{Input: strings.NewReader("111111\n")}, "111111", false},
+		{"terminal without newline", TerminalToken{Input: strings.NewReader("222222")}, "222222", false},
+		{"empty terminal", TerminalToken{Input: strings.NewReader("\n")}, "", true},
+	}
+
+	if runtime.GOOS != "windows" {
+		tests = append(tests,
+			test{"process", ProcessToken("echo 333333"), "333333", false},
+			test{"failing process", ProcessToken("exit 1"), "", true},
+		)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.provider.Token(testSerial)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Token() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Token() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTP settings, either the secret or the secret file is required
+	totpSecretKey       = `mfa_totp_secret`
+	totpSecretFileKey   = `mfa_totp_secret_file`
+	totpPeriodKey       = `mfa_totp_period`        // optional, defaults to 30s
+	totpDigitsKey       = `mfa_totp_digits`        // optional, defaults to 6
+	totpAlgorithmKey    = `mfa_totp_algorithm`     // optional, defaults to SHA1
+	totpMinRemainingKey = `mfa_totp_min_remaining` // optional, defaults to 5s
+)
+
+var totpAlgorithms = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// TOTPToken generates token codes from a shared secret as described in
+// RFC 6238, the same way a virtual MFA device does.
+type TOTPToken struct {
+	Secret    []byte
+	Period    time.Duration
+	Digits    int
+	Algorithm func() hash.Hash
+
+	// MinRemaining is how long a code must remain valid for it to be used,
+	// otherwise we wait for the next one so it doesn't expire in flight.
+	MinRemaining time.Duration
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// hasTOTPSecret returns true when a TOTP secret is configured
+func hasTOTPSecret(settings Settings) bool {
+	return settings.String(totpSecretKey) != "" || settings.String(totpSecretFileKey) != ""
+}
+
+// NewTOTPToken reads the TOTP settings for a profile.
+func NewTOTPToken(settings Settings) (*TOTPToken, error) {
+	secret := settings.String(totpSecretKey)
+	if file := settings.String(totpSecretFileKey); secret == "" && file != "" {
+		contents, err := ioutil.ReadFile(expandHome(file))
+		if err != nil {
+			return nil, err
+		}
+		secret = string(contents)
+	}
+
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TOTPToken{
+		Secret:       key,
+		Period:       30 * time.Second,
+		Digits:       6,
+		Algorithm:    sha1.New,
+		MinRemaining: 5 * time.Second,
+	}
+
+	if key, _, ok := settings.Key(totpPeriodKey); ok {
+		seconds, err := key.Int()
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid %s: %s", totpPeriodKey, key.String())
+		}
+		t.Period = time.Duration(seconds) * time.Second
+	}
+	if key, _, ok := settings.Key(totpDigitsKey); ok {
+		digits, err := key.Int()
+		if err != nil || digits < 6 || digits > 8 {
+			return nil, fmt.Errorf("invalid %s: %s, must be between 6 and 8", totpDigitsKey, key.String())
+		}
+		t.Digits = digits
+	}
+	if key, _, ok := settings.Key(totpAlgorithmKey); ok {
+		algorithm, ok := totpAlgorithms[strings.ToUpper(strings.Replace(key.String(), "-", "", -1))]
+		if !ok {
+			return nil, fmt.Errorf("invalid %s: %s, must be one of SHA1, SHA256 or SHA512", totpAlgorithmKey, key.String())
+		}
+		t.Algorithm = algorithm
+	}
+	if key, _, ok := settings.Key(totpMinRemainingKey); ok {
+		seconds, err := key.Int()
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("invalid %s: %s", totpMinRemainingKey, key.String())
+		}
+		t.MinRemaining = time.Duration(seconds) * time.Second
+	}
+	if t.MinRemaining >= t.Period {
+		return nil, fmt.Errorf("%s must be less than %s", totpMinRemainingKey, totpPeriodKey)
+	}
+
+	return t, nil
+}
+
+// decodeTOTPSecret decodes a base32 secret, ignoring case, spaces and padding
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Join(strings.Fields(secret), ""))
+	secret = strings.TrimRight(secret, "=")
+	if secret == "" {
+		return nil, fmt.Errorf("empty TOTP secret")
+	}
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base32 TOTP secret: %v", err)
+	}
+	return key, nil
+}
+
+func (t TOTPToken) Token(serial string) (string, error) {
+	now, sleep := time.Now, time.Sleep
+	if t.now != nil {
+		now = t.now
+	}
+	if t.sleep != nil {
+		sleep = t.sleep
+	}
+
+	current := now()
+	remaining := t.Period - time.Duration(current.UnixNano()%int64(t.Period))
+	if remaining < t.MinRemaining {
+		log.WithField("prefix", "token").Infof("Waiting %s for the next TOTP code", remaining.Round(time.Second))
+		sleep(remaining)
+		current = current.Add(remaining)
+	}
+
+	return t.Generate(current), nil
+}
+
+// Generate returns the code for the window containing the given time.
+func (t TOTPToken) Generate(at time.Time) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(at.Unix()/int64(t.Period/time.Second)))
+
+	mac := hmac.New(t.Algorithm, t.Secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < t.Digits; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", t.Digits, code%modulo)
+}
@@ -0,0 +1,111 @@
+package mfa
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"hash"
+	"testing"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+// test vectors from RFC 6238 appendix B
+func TestTOTPGenerate(t *testing.T) {
+	secrets := map[string][]byte{
+		"SHA1":   []byte("12345678901234567890"),
+		"SHA256": []byte("12345678901234567890123456789012"),
+		"SHA512": []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	algorithms := map[string]func() hash.Hash{
+		"SHA1":   sha1.New,
+		"SHA256": sha256.New,
+		"SHA512": sha512.New,
+	}
+
+	tests := []struct {
+		unix      int64
+		algorithm string
+		want      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1234567890, "SHA1", "89005924"},
+		{2000000000, "SHA1", "69279037"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, tt := range tests {
+		totp := TOTPToken{
+			Secret:    secrets[tt.algorithm],
+			Period:    30 * time.Second,
+			Digits:    8,
+			Algorithm: algorithms[tt.algorithm],
+		}
+		if got := totp.Generate(time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("Generate(%d) with %s = %s, want %s", tt.unix, tt.algorithm, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPTokenWaitsForNextWindow(t *testing.T) {
+	var slept time.Duration
+	totp := TOTPToken{
+		Secret:       []byte("12345678901234567890"),
+		Period:       30 * time.Second,
+		Digits:       6,
+		Algorithm:    sha1.New,
+		MinRemaining: 5 * time.Second,
+		now:          func() time.Time { return time.Unix(58, 0) },
+		sleep:        func(d time.Duration) { slept = d },
+	}
+
+	got, err := totp.Token(testSerial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slept != 2*time.Second {
+		t.Errorf("slept %s, want 2s", slept)
+	}
+	if want := totp.Generate(time.Unix(60, 0)); got != want {
+		t.Errorf("Token() = %s, want the next window's code %s", got, want)
+	}
+}
+
+func TestNewTOTPToken(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		name    string
+		keys    map[string]string
+		wantErr bool
+	}{
+		{"defaults", map[string]string{totpSecretKey: secret}, false},
+		{"lowercase with spaces", map[string]string{totpSecretKey: "gezd gnbv gy3t qojq gezd gnbv gy3t qojq"}, false},
+		{"all settings", map[string]string{totpSecretKey: secret, totpPeriodKey: "60", totpDigitsKey: "8", totpAlgorithmKey: "sha-256", totpMinRemainingKey: "10"}, false},
+		{"invalid secret", map[string]string{totpSecretKey: "not base32!"}, true},
+		{"invalid digits", map[string]string{totpSecretKey: secret, totpDigitsKey: "4"}, true},
+		{"invalid algorithm", map[string]string{totpSecretKey: secret, totpAlgorithmKey: "MD5"}, true},
+		{"min remaining longer than the period", map[string]string{totpSecretKey: secret, totpMinRemainingKey: "30"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := ini.Empty().Section("default-permanent")
+			for k, v := range tt.keys {
+				section.Key(k).SetValue(v)
+			}
+
+			_, err := NewTOTPToken(Settings{{Profile: "default-permanent", Section: section}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewTOTPToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
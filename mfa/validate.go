@@ -0,0 +1,232 @@
+package mfa
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ConfigError is a problem with the files or options a profile is loaded
+// from, that can be fixed by the user.
+type ConfigError interface {
+	error
+
+	// Fix suggests what to do about the problem
+	Fix() string
+}
+
+// MissingFileError means the credentials file can't be read
+type MissingFileError struct {
+	Path string
+	Err  error
+}
+
+func (e *MissingFileError) Error() string {
+	if os.IsNotExist(e.Err) {
+		return fmt.Sprintf("the credentials file %s doesn't exist", e.Path)
+	}
+	return fmt.Sprintf("failed to read the credentials file %s: %v", e.Path, e.Err)
+}
+
+func (e *MissingFileError) Fix() string {
+	if os.IsNotExist(e.Err) {
+		return "Run 'aws-mfa init' to create it, or point --credentials at the right file"
+	}
+	return "Check the permissions of the credentials file, it needs to be readable and writable by you"
+}
+
+// MissingSectionError means a section the profile needs isn't in the
+// credentials file. The section is the permanent one unless it was named by
+// source_profile.
+type MissingSectionError struct {
+	Profile       string
+	Section       string
+	Suffix        string
+	Path          string
+	SourceProfile bool
+
+	// Migratable is set when the profile's own section has a long-lived
+	// access key that could be moved to the permanent section
+	Migratable bool
+}
+
+func (e *MissingSectionError) Error() string {
+	if e.SourceProfile {
+		return fmt.Sprintf("the [%s] section named by source_profile for %s isn't in %s", e.Section, e.Profile, e.Path)
+	}
+	return fmt.Sprintf("the permanent section [%s] of %s isn't in %s", e.Section, e.Profile, e.Path)
+}
+
+func (e *MissingSectionError) Fix() string {
+	switch {
+	case e.SourceProfile:
+		return fmt.Sprintf("Add a [%s] section with your access key, or point source_profile at the section that has it", e.Section)
+	case e.Migratable:
+		return fmt.Sprintf("Run 'aws-mfa migrate --profile %s' to rename [%s] to [%s]", e.Profile, e.Profile, e.Section)
+	case e.Suffix != "" && strings.HasSuffix(e.Profile, "-"+e.Suffix):
+		return fmt.Sprintf("Use --profile %s, the suffix is added to the profile to find the permanent section", strings.TrimSuffix(e.Profile, "-"+e.Suffix))
+	default:
+		return fmt.Sprintf("Run 'aws-mfa init --profile %s' to create it, rename the section holding your access key to [%s], or use --suffix if it ends in something else", e.Profile, e.Section)
+	}
+}
+
+// MissingKeyError means a section is missing some of a group of keys that
+// have to be set together
+type MissingKeyError struct {
+	Section string
+	Keys    []string
+}
+
+func (e *MissingKeyError) Error() string {
+	return fmt.Sprintf("the [%s] section is missing %s", e.Section, strings.Join(e.Keys, " and "))
+}
+
+func (e *MissingKeyError) Fix() string {
+	return fmt.Sprintf("Add %s to [%s], or set %s if the access key is kept elsewhere", strings.Join(e.Keys, " and "), e.Section, keyStoreKey)
+}
+
+// InvalidMFASerialError means the MFA serial is neither the ARN of a virtual
+// device nor the serial number of a hardware one
+type InvalidMFASerialError struct {
+	Serial string
+
+	// Source is where the serial came from, --mfa or the section it's in
+	Source string
+}
+
+func (e *InvalidMFASerialError) Error() string {
+	return fmt.Sprintf("%q from %s isn't a valid MFA device", e.Serial, e.Source)
+}
+
+func (e *InvalidMFASerialError) Fix() string {
+	return "Use the ARN of your MFA device as shown in the IAM console, like arn:aws:iam::<account-id>:mfa/<device>, or the serial number of a hardware device"
+}
+
+// SameProfileError means the temporary credentials would be written over the
+// credentials used to request them
+type SameProfileError struct {
+	Profile string
+
+	// Key is the setting that points back at the temporary profile, empty
+	// when the permanent profile has the same name
+	Key string
+}
+
+func (e *SameProfileError) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("%s of %s is the profile its temporary credentials are written to", e.Key, e.Profile)
+	}
+	return fmt.Sprintf("the temporary and permanent profiles are both [%s]", e.Profile)
+}
+
+func (e *SameProfileError) Fix() string {
+	if e.Key != "" {
+		return fmt.Sprintf("Point %s at the section holding your access key", e.Key)
+	}
+	return "Set --suffix so the permanent section has a name of its own"
+}
+
+// mfaSerialPattern matches the ARN of a virtual or U2F MFA device, in any
+// partition
+var mfaSerialPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:(mfa|u2f)/[\w+=,.@/-]+$`)
+
+// hardwareSerialPattern matches the serial number of a hardware MFA device
+var hardwareSerialPattern = regexp.MustCompile(`^[\w+=/:,.@-]{9,256}$`)
+
+// validMFASerial returns true if serial is a virtual or hardware MFA device
+func validMFASerial(serial string) bool {
+	if strings.HasPrefix(serial, "arn:") {
+		return mfaSerialPattern.MatchString(serial)
+	}
+	return hardwareSerialPattern.MatchString(serial)
+}
+
+// check returns the problems with a loaded config that don't stop the rest
+// of it from being loaded, but would stop it from being refreshed
+func (c *Config) check() []error {
+	var problems []error
+
+	store, err := c.keyStore(c.Source)
+	if err != nil {
+		problems = append(problems, err)
+	} else if _, ok := store.(iniKeyStore); ok {
+		var missing []string
+		for _, key := range []string{accessKeyIDKey, secretAccessKey} {
+			if !c.Source.Section.HasKey(key) || c.Source.Section.Key(key).String() == "" {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			problems = append(problems, &MissingKeyError{Section: c.Source.Profile, Keys: missing})
+		}
+	}
+
+	if serial := c.Options.MFASerial; serial != "" && !validMFASerial(serial) {
+		source := "--mfa"
+		if key, profile, ok := c.Settings.Key(mfaSerialKey); ok && key.String() == serial {
+			source = fmt.Sprintf("%s in [%s]", mfaSerialKey, profile)
+		}
+		problems = append(problems, &InvalidMFASerialError{Serial: serial, Source: source})
+	}
+
+	return problems
+}
+
+// Problem is something wrong with the configuration of a profile, Profile is
+// empty for problems with the files every profile is loaded from.
+type Problem struct {
+	Profile string
+	Err     error
+}
+
+// Fix suggests what to do about the problem, if anything is known
+func (p Problem) Fix() string {
+	if err, ok := p.Err.(ConfigError); ok {
+		return err.Fix()
+	}
+	return ""
+}
+
+// Diagnose makes the same checks as ValidateProfiles, but carries on after a
+// profile fails to return every problem found. If no profiles are given,
+// every profile with a permanent section is checked.
+func (o Options) Diagnose(profiles []string) []Problem {
+	o = o.withDefaults()
+
+	lock, err := o.lockCredentials()
+	if err != nil {
+		return []Problem{{Err: err}}
+	}
+	defer lock.Unlock()
+
+	files, err := o.loadFiles(lock)
+	if err != nil {
+		return []Problem{{Err: err}}
+	}
+	defer files.store.unlock()
+
+	if len(profiles) == 0 {
+		if profiles, err = files.profiles(o); err != nil {
+			return []Problem{{Err: err}}
+		}
+	}
+
+	var problems []Problem
+	for _, profile := range profiles {
+		options := o
+		options.Profile = profile
+
+		config, err := options.profileConfig(files.credentials, files.config, files.store)
+		if err != nil {
+			problems = append(problems, Problem{Profile: profile, Err: err})
+			continue
+		}
+
+		for _, err := range config.check() {
+			problems = append(problems, Problem{Profile: profile, Err: err})
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,177 @@
+package mfa
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials string
+		options     Options
+		noSuffix    bool
+		want        interface{}
+		wantMigrate bool
+	}{
+		{
+			name: "missing file",
+			want: &MissingFileError{},
+		},
+		{
+			name:        "missing permanent section",
+			credentials: "[default]\naws_access_key_id = AKIAPERMANENT\naws_secret_access_key = permanent-secret\n",
+			want:        &MissingSectionError{},
+			wantMigrate: true,
+		},
+		{
+			name:        "missing permanent section with temporary credentials",
+			credentials: temporarySection(time.Now()),
+			want:        &MissingSectionError{},
+		},
+		{
+			name:        "missing source profile",
+			credentials: permanentSection("role_arn = arn:aws:iam::123456789012:role/admin\nsource_profile = other"),
+			want:        &MissingSectionError{},
+		},
+		{
+			name:        "missing secret",
+			credentials: "[default-permanent]\naws_access_key_id = AKIAPERMANENT\n",
+			want:        &MissingKeyError{},
+		},
+		{
+			name:        "malformed mfa_serial",
+			credentials: permanentSection("mfa_serial = arn:aws:iam::1234:mfa/test"),
+			want:        &InvalidMFASerialError{},
+		},
+		{
+			name:        "malformed --mfa",
+			credentials: permanentSection(""),
+			options:     Options{MFASerial: "test"},
+			want:        &InvalidMFASerialError{},
+		},
+		{
+			name:        "no suffix",
+			credentials: permanentSection(""),
+			noSuffix:    true,
+			want:        &SameProfileError{},
+		},
+		{
+			name:        "source profile is the temporary profile",
+			credentials: permanentSection("role_arn = arn:aws:iam::123456789012:role/admin\nsource_profile = default"),
+			want:        &SameProfileError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, cleanup := writeCredentials(t, tt.credentials)
+			defer cleanup()
+			if tt.credentials == "" {
+				os.Remove(path)
+			}
+
+			options := tt.options
+			options.CredentialsFileLocation = path
+			options.Profile = "default"
+			if !tt.noSuffix {
+				options.ProfileSuffix = "permanent"
+			}
+
+			config, err := options.Validate()
+			if err == nil {
+				config.Close()
+				t.Fatal("Validate() should fail")
+			}
+			if reflect.TypeOf(err) != reflect.TypeOf(tt.want) {
+				t.Fatalf("Validate() error = %T %v, want a %T", err, err, tt.want)
+			}
+			configErr, ok := err.(ConfigError)
+			if !ok || configErr.Fix() == "" {
+				t.Fatalf("%T doesn't suggest a fix", err)
+			}
+			if got := strings.Contains(configErr.Fix(), "aws-mfa migrate"); got != tt.wantMigrate {
+				t.Errorf("Fix() = %q, suggests migrating: %v, want %v", configErr.Fix(), got, tt.wantMigrate)
+			}
+		})
+	}
+}
+
+func TestValidMFASerial(t *testing.T) {
+	tests := []struct {
+		serial string
+		want   bool
+	}{
+		{testSerial, true},
+		{"arn:aws:iam::123456789012:mfa/path/to/device", true},
+		{"arn:aws-us-gov:iam::123456789012:mfa/test", true},
+		{"arn:aws:iam::123456789012:u2f/user/test/default-ABCDEF", true},
+		{"GAHT12345678", true},
+		{"arn:aws:iam::1234:mfa/test", false},
+		{"arn:aws:iam::123456789012:user/test", false},
+		{"arn:aws:sts::123456789012:mfa/test", false},
+		{"123456", false},
+		{"my device", false},
+	}
+
+	for _, tt := range tests {
+		if got := validMFASerial(tt.serial); got != tt.want {
+			t.Errorf("validMFASerial(%q) = %v, want %v", tt.serial, got, tt.want)
+		}
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	path, cleanup := writeCredentials(t, permanentSection("mfa_serial = "+testSerial)+`
+[work-permanent]
+aws_access_key_id = AKIAWORK
+mfa_serial        = work
+
+[admin-permanent]
+aws_access_key_id     = AKIAADMIN
+aws_secret_access_key = admin-secret
+role_arn              = arn:aws:iam::123456789012:role/admin
+source_profile        = admin
+`)
+	defer cleanup()
+
+	options := Options{
+		CredentialsFileLocation: path,
+		ProfileSuffix:           "permanent",
+	}
+
+	problems := options.Diagnose(nil)
+
+	var got []string
+	for _, problem := range problems {
+		got = append(got, problem.Profile+": "+reflect.TypeOf(problem.Err).String())
+		if problem.Fix() == "" {
+			t.Errorf("%s: %v doesn't suggest a fix", problem.Profile, problem.Err)
+		}
+	}
+	want := []string{
+		"work: *mfa.MissingKeyError",
+		"work: *mfa.InvalidMFASerialError",
+		"admin: *mfa.SameProfileError",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diagnose() = %v, want %v", got, want)
+	}
+
+	if problems := options.Diagnose([]string{"default"}); len(problems) != 0 {
+		t.Errorf("Diagnose(default) = %v, want no problems", problems)
+	}
+
+	options.CredentialsFileLocation = filepath.Join(filepath.Dir(path), "missing")
+	problems = options.Diagnose(nil)
+	if len(problems) != 1 || problems[0].Profile != "" {
+		t.Fatalf("Diagnose() of a missing file = %v, want a single problem", problems)
+	}
+	if _, ok := problems[0].Err.(*MissingFileError); !ok {
+		t.Errorf("Diagnose() of a missing file = %T, want a *MissingFileError", problems[0].Err)
+	}
+}